@@ -80,7 +80,6 @@ func (s *StreamService) Update(stream pb.StreamService_UpdateServer) error {
 			okCount++
 		}
 	}
-	return nil
 }
 
 func (s *StreamService) Check(stream pb.StreamService_CheckServer) error {
@@ -107,5 +106,4 @@ func (s *StreamService) Check(stream pb.StreamService_CheckServer) error {
 		}
 
 	}
-	return nil
 }
@@ -0,0 +1,338 @@
+package myRPC
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/metadata"
+	"rpc/myRPC/status"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthorizationKey is the metadata key carrying the credentials of a call,
+// such as "Bearer <token>". The gateway fills it from the Authorization header.
+// The credentials are sent in the clear, send them over TLS only.
+const AuthorizationKey = "authorization"
+
+// Principal is the identity a call is authenticated as, handlers get it with
+// PrincipalFromContext.
+type Principal struct {
+	Name string
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the principal of the call handled with ctx,
+// if it has been authenticated.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// AuthInfo is what an Authenticator authenticates a call with.
+type AuthInfo struct {
+	ServiceMethod string // format "Service.Method"
+	// Credentials is sent by the client in the handshake with Option.Credentials,
+	// it is the same for all the calls of the connection.
+	Credentials string
+	Metadata    metadata.MD // the metadata of the call
+	Peer        *Peer       // the peer of the call, nil if unknown
+	// Args is the argument of the call, nil for a stream whose messages follow.
+	Args interface{}
+}
+
+// Authorization returns the credentials of the call: the "authorization"
+// metadata of the call if it is set, the credentials of the handshake otherwise.
+func (info *AuthInfo) Authorization() string {
+	if auth := info.Metadata.Get(AuthorizationKey); auth != "" {
+		return auth
+	}
+	return info.Credentials
+}
+
+// Authenticator validates the credentials of a call and returns its principal.
+// It returns a nil principal and a nil error when the call carries no
+// credentials it knows about, so that the next Authenticator can try them.
+// An error that is not a status is sent to the caller as codes.Unauthenticated.
+type Authenticator func(ctx context.Context, info *AuthInfo) (*Principal, error)
+
+// Authentication returns a ServerOption that authenticates every call before
+// it reaches the method. The authenticators are tried in order until one
+// returns a principal or an error, a call none of them knows is anonymous.
+// The principal is attached to the context of the method.
+func Authentication(authenticators ...Authenticator) ServerOption {
+	return func(server *Server) {
+		server.authenticators = append(server.authenticators, authenticators...)
+	}
+}
+
+// AccessList returns a ServerOption that rejects the calls acl does not allow.
+// A call with no principal is answered with codes.Unauthenticated, a principal
+// calling a method it is not allowed to with codes.PermissionDenied.
+func AccessList(acl *ACL) ServerOption {
+	return func(server *Server) {
+		server.acl = acl
+	}
+}
+
+// ACL maps principals to the methods they may call. Methods are matched
+// with patterns in the syntax of path.Match, such as "Arith.Mul", "Arith.*"
+// or "*". An ACL must not be modified once the server serves with it.
+type ACL struct {
+	anonymous []string
+	allowed   map[string][]string // principal name or "*" -> patterns
+}
+
+// NewACL returns an ACL that allows nothing.
+func NewACL() *ACL {
+	return &ACL{allowed: make(map[string][]string)}
+}
+
+// Allow allows the principal with name to call the methods matching patterns,
+// the name "*" stands for any authenticated principal.
+func (acl *ACL) Allow(name string, patterns ...string) *ACL {
+	acl.allowed[name] = append(acl.allowed[name], patterns...)
+	return acl
+}
+
+// AllowAnonymous allows calls with no principal to call the methods matching patterns.
+func (acl *ACL) AllowAnonymous(patterns ...string) *ACL {
+	acl.anonymous = append(acl.anonymous, patterns...)
+	return acl
+}
+
+// Allowed reports whether p, nil for an anonymous call, may call serviceMethod.
+func (acl *ACL) Allowed(p *Principal, serviceMethod string) bool {
+	if p == nil {
+		return matchAny(acl.anonymous, serviceMethod)
+	}
+	return matchAny(acl.allowed[p.Name], serviceMethod) || matchAny(acl.allowed["*"], serviceMethod)
+}
+
+func matchAny(patterns []string, serviceMethod string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, serviceMethod); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type credentialsKey struct{}
+
+// withCredentials returns ctx carrying the credentials of the handshake.
+func withCredentials(ctx context.Context, credentials string) context.Context {
+	if credentials == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, credentialsKey{}, credentials)
+}
+
+// authorize authenticates the call of serviceMethod with args handled with ctx
+// and checks it against the ACL. It returns ctx carrying the principal.
+func (server *Server) authorize(ctx context.Context, serviceMethod string, args interface{}) (context.Context, error) {
+	if len(server.authenticators) == 0 && server.acl == nil {
+		return ctx, nil
+	}
+	info := &AuthInfo{ServiceMethod: serviceMethod, Args: args}
+	info.Credentials, _ = ctx.Value(credentialsKey{}).(string)
+	info.Metadata, _ = metadata.FromIncomingContext(ctx)
+	info.Peer, _ = PeerFromContext(ctx)
+
+	var principal *Principal
+	for _, authenticate := range server.authenticators {
+		p, err := authenticate(ctx, info)
+		if err != nil {
+			if _, ok := status.FromError(err); ok {
+				return ctx, err
+			}
+			return ctx, status.Error(codes.Unauthenticated, "rpc: "+err.Error())
+		}
+		if p != nil {
+			principal = p
+			break
+		}
+	}
+	if server.acl != nil && !server.acl.Allowed(principal, serviceMethod) {
+		if principal == nil {
+			return ctx, status.Error(codes.Unauthenticated, "rpc: "+serviceMethod+" requires credentials")
+		}
+		return ctx, status.Errorf(codes.PermissionDenied, "rpc: %s may not call %s", principal.Name, serviceMethod)
+	}
+	if principal == nil {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, principalKey{}, principal), nil
+}
+
+// BearerTokens returns an Authenticator of the credentials "Bearer <token>",
+// tokens maps the tokens to the names of their principals. A token is good for
+// any call until it is removed, send it over TLS only.
+func BearerTokens(tokens map[string]string) Authenticator {
+	return func(ctx context.Context, info *AuthInfo) (*Principal, error) {
+		token, ok := cutScheme(info.Authorization(), "Bearer")
+		if !ok {
+			return nil, nil
+		}
+		// compare with every token in constant time, not to leak the valid ones
+		var name string
+		for t, n := range tokens {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				name = n
+			}
+		}
+		if name == "" {
+			return nil, status.Error(codes.Unauthenticated, "rpc: invalid bearer token")
+		}
+		return &Principal{Name: name}, nil
+	}
+}
+
+// HMACKeys returns an Authenticator of the credentials signed by SignHMAC,
+// keys maps the key ids to the keys, the key id is the name of the principal.
+// A signature is only good for the method and the arguments it was made for,
+// and only once: signatures older or newer than maxSkew are rejected, and
+// the nonces of the others are remembered for maxSkew.
+func HMACKeys(keys map[string][]byte, maxSkew time.Duration) Authenticator {
+	nonces := &nonceCache{ttl: 2 * maxSkew, seen: make(map[string]time.Time)}
+	return func(ctx context.Context, info *AuthInfo) (*Principal, error) {
+		cred, ok := cutScheme(info.Authorization(), "HMAC")
+		if !ok {
+			return nil, nil
+		}
+		parts := strings.SplitN(cred, ":", 4)
+		if len(parts) != 4 {
+			return nil, status.Error(codes.Unauthenticated, "rpc: malformed HMAC credentials")
+		}
+		keyID, ts, nonce := parts[0], parts[1], parts[2]
+		key, ok := keys[keyID]
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "rpc: unknown HMAC key "+strconv.Quote(keyID))
+		}
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "rpc: malformed HMAC timestamp")
+		}
+		if skew := time.Since(time.Unix(sec, 0)); skew > maxSkew || skew < -maxSkew {
+			return nil, status.Error(codes.Unauthenticated, "rpc: HMAC signature expired")
+		}
+		mac, err := macHMAC(keyID, key, ts, nonce, info.ServiceMethod, info.Args)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "rpc: HMAC: "+err.Error())
+		}
+		if !hmac.Equal([]byte(mac), []byte(parts[3])) {
+			return nil, status.Error(codes.Unauthenticated, "rpc: invalid HMAC signature")
+		}
+		if !nonces.add(keyID+":"+nonce, time.Now()) {
+			return nil, status.Error(codes.Unauthenticated, "rpc: HMAC signature replayed")
+		}
+		return &Principal{Name: keyID}, nil
+	}
+}
+
+// SignHMAC returns the credentials of a call of serviceMethod with args at t,
+// signed with the key with keyID, in the format
+// "HMAC <keyID>:<unix time>:<nonce>:<signature>". The signature covers the
+// JSON encoding of args, the server checks it against the arguments it
+// decodes, so args must encode as the argument type of the method does.
+// Each call needs credentials of its own, HMACKeys rejects a replayed nonce.
+func SignHMAC(keyID string, key []byte, serviceMethod string, args interface{}, t time.Time) (string, error) {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	ts := strconv.FormatInt(t.Unix(), 10)
+	nonce := base64.RawURLEncoding.EncodeToString(b[:])
+	mac, err := macHMAC(keyID, key, ts, nonce, serviceMethod, args)
+	if err != nil {
+		return "", err
+	}
+	return "HMAC " + keyID + ":" + ts + ":" + nonce + ":" + mac, nil
+}
+
+// macHMAC returns the signature of SignHMAC.
+func macHMAC(keyID string, key []byte, ts, nonce, serviceMethod string, args interface{}) (string, error) {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return "", fmt.Errorf("can not encode the arguments: %v", err)
+	}
+	digest := sha256.Sum256(data)
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%x", keyID, ts, nonce, serviceMethod, digest)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// nonceCache remembers the nonces seen for ttl.
+type nonceCache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex // protect following
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+// add records nonce seen at now, it reports false if nonce has been seen already.
+func (c *nonceCache) add(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastSweep) > c.ttl {
+		for n, seen := range c.seen {
+			if now.Sub(seen) > c.ttl {
+				delete(c.seen, n)
+			}
+		}
+		c.lastSweep = now
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	c.seen[nonce] = now
+	return true
+}
+
+// HMACInterceptor returns a UnaryClientInterceptor that signs every call with
+// the key with keyID, it sets the "authorization" metadata of the call.
+func HMACInterceptor(keyID string, key []byte) UnaryClientInterceptor {
+	return func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error {
+		auth, err := SignHMAC(keyID, key, serviceMethod, args, time.Now())
+		if err != nil {
+			return status.Error(codes.InvalidArgument, "rpc: HMAC: "+err.Error())
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, AuthorizationKey, auth)
+		return invoker(ctx, serviceMethod, args, reply)
+	}
+}
+
+// TLSCertificates returns an Authenticator of the certificates of the clients
+// verified by mutual TLS, the common name of the certificate is the name of
+// the principal.
+func TLSCertificates() Authenticator {
+	return func(ctx context.Context, info *AuthInfo) (*Principal, error) {
+		if info.Peer == nil {
+			return nil, nil
+		}
+		cert := info.Peer.Certificate()
+		if cert == nil || len(info.Peer.TLS.VerifiedChains) == 0 {
+			return nil, nil
+		}
+		return &Principal{Name: cert.Subject.CommonName}, nil
+	}
+}
+
+// cutScheme returns the credentials of auth in the scheme, such as "Bearer".
+func cutScheme(auth, scheme string) (string, bool) {
+	if len(auth) <= len(scheme) || !strings.EqualFold(auth[:len(scheme)], scheme) || auth[len(scheme)] != ' ' {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(scheme)+1:]), true
+}
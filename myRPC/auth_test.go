@@ -0,0 +1,254 @@
+package myRPC
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/metadata"
+	"rpc/myRPC/status"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Account struct {
+	calls int64
+}
+
+// Name replies the name of the principal of the caller, "anonymous" without one.
+func (a *Account) Name(ctx context.Context, _ int, reply *string) error {
+	atomic.AddInt64(&a.calls, 1)
+	*reply = "anonymous"
+	if p, ok := PrincipalFromContext(ctx); ok {
+		*reply = p.Name
+	}
+	return nil
+}
+
+// Secret is only allowed to admins.
+func (a *Account) Secret(_ int, reply *string) error {
+	atomic.AddInt64(&a.calls, 1)
+	*reply = "secret"
+	return nil
+}
+
+// Watch sends the name of the principal of the caller n times.
+func (a *Account) Watch(n int, stream *ServerStream) error {
+	atomic.AddInt64(&a.calls, 1)
+	p, ok := PrincipalFromContext(stream.Context())
+	if !ok {
+		return errors.New("no principal")
+	}
+	for i := 0; i < n; i++ {
+		if err := stream.Send(p.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var hmacKey = []byte("0123456789abcdef")
+
+func newAuthServer() (*Server, *Account) {
+	acl := NewACL().
+		AllowAnonymous("Calc.*").
+		Allow("*", "Account.Name", "Account.Watch").
+		Allow("admin", "Account.*")
+	server := NewServer(
+		Authentication(BearerTokens(map[string]string{"tok-a": "alice", "tok-r": "admin"}), HMACKeys(map[string][]byte{"bob": hmacKey}, time.Minute)),
+		AccessList(acl),
+	)
+	account := new(Account)
+	_assert(server.Register(account) == nil, "register Account")
+	_assert(server.Register(new(Calc)) == nil, "register Calc")
+	return server, account
+}
+
+func TestServer_Authentication(t *testing.T) {
+	server, account := newAuthServer()
+	addr := startTestServer(t, server, (*Server).Accept)
+
+	dial := func(opt Option) *Client {
+		client, err := Dial("tcp", addr, &opt)
+		_assert(err == nil, "dial: %v", err)
+		return client
+	}
+	name := func(ctx context.Context, client *Client) (string, error) {
+		var name string
+		err := client.Call(ctx, "Account.Name", 0, &name)
+		return name, err
+	}
+	ctx := context.Background()
+
+	for _, opt := range codecOptions() {
+		// anonymous callers only reach Calc
+		client := dial(opt)
+		var sum int
+		err := client.Call(ctx, "Calc.Add", Args{Num1: 1, Num2: 2}, &sum)
+		_assert(err == nil && sum == 3, "expect 3, got %d %v", sum, err)
+		_, err = name(ctx, client)
+		_assert(status.Code(err) == codes.Unauthenticated, "expect Unauthenticated, got %v", err)
+		_ = client.Close()
+
+		// credentials of the handshake, overridden by the metadata of a call
+		opt.Credentials = "Bearer tok-a"
+		client = dial(opt)
+		n, err := name(ctx, client)
+		_assert(err == nil && n == "alice", "%s: expect alice, got %q %v", opt.CodeType, n, err)
+		n, err = name(metadata.AppendToOutgoingContext(ctx, AuthorizationKey, "Bearer tok-r"), client)
+		_assert(err == nil && n == "admin", "expect admin, got %q %v", n, err)
+		_, err = name(metadata.AppendToOutgoingContext(ctx, AuthorizationKey, "Bearer nope"), client)
+		_assert(status.Code(err) == codes.Unauthenticated, "expect Unauthenticated for a bad token, got %v", err)
+		_ = client.Close()
+	}
+
+	// the ACL rejects the call before it reaches the method
+	opt := DefaultOption
+	opt.Credentials = "Bearer tok-a"
+	client := dial(opt)
+	defer func() { _ = client.Close() }()
+	before := atomic.LoadInt64(&account.calls)
+	var secret string
+	err := client.Call(ctx, "Account.Secret", 0, &secret)
+	_assert(status.Code(err) == codes.PermissionDenied, "expect PermissionDenied, got %v", err)
+	_assert(atomic.LoadInt64(&account.calls) == before, "the method must not be called")
+	err = client.Call(metadata.AppendToOutgoingContext(ctx, AuthorizationKey, "Bearer tok-r"), "Account.Secret", 0, &secret)
+	_assert(err == nil && secret == "secret", "expect admin to get the secret, got %q %v", secret, err)
+
+	// streams carry the principal too
+	stream, err := client.NewServerStream(ctx, "Account.Watch", 2)
+	_assert(err == nil, "open stream: %v", err)
+	for i := 0; i < 2; i++ {
+		var n string
+		err := stream.Recv(&n)
+		_assert(err == nil && n == "alice", "expect alice, got %q %v", n, err)
+	}
+	var n string
+	_assert(stream.Recv(&n) == io.EOF, "expect the end of the stream")
+	anonymous := dial(DefaultOption)
+	defer func() { _ = anonymous.Close() }()
+	stream, err = anonymous.NewServerStream(ctx, "Account.Watch", 2)
+	_assert(err == nil, "open stream: %v", err)
+	err = stream.Recv(&n)
+	_assert(status.Code(err) == codes.Unauthenticated, "expect Unauthenticated stream, got %v", err)
+}
+
+func TestServer_JSONRPCAuthentication(t *testing.T) {
+	server, _ := newAuthServer()
+	ts := httptest.NewServer(server.JSONRPCHandler())
+	defer ts.Close()
+
+	call := func(auth string) jsonrpcResult {
+		req, err := http.NewRequest("POST", ts.URL, strings.NewReader(`{"jsonrpc":"2.0","method":"Account.Name","params":0,"id":1}`))
+		_assert(err == nil, "new request: %v", err)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		_assert(err == nil, "post: %v", err)
+		defer func() { _ = resp.Body.Close() }()
+		var result jsonrpcResult
+		_assert(json.NewDecoder(resp.Body).Decode(&result) == nil, "decode response")
+		return result
+	}
+	result := call("Bearer tok-a")
+	_assert(result.Error == nil && string(result.Result) == `"alice"`, "expect alice, got %s %+v", result.Result, result.Error)
+	result = call("")
+	_assert(result.Error != nil && codes.Code(result.Error.Data.Code) == codes.Unauthenticated,
+		"expect Unauthenticated without credentials, got %+v", result.Error)
+	result = call("Bearer nope")
+	_assert(result.Error != nil && codes.Code(result.Error.Data.Code) == codes.Unauthenticated,
+		"expect Unauthenticated for unknown credentials, got %+v", result.Error)
+}
+
+func TestServer_HMACAuthentication(t *testing.T) {
+	server, _ := newAuthServer()
+	addr := startTestServer(t, server, (*Server).Accept)
+
+	opt := DefaultOption
+	opt.UnaryInterceptor = HMACInterceptor("bob", hmacKey)
+	client, err := Dial("tcp", addr, &opt)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var name string
+	err = client.Call(context.Background(), "Account.Name", 0, &name)
+	_assert(err == nil && name == "bob", "expect bob, got %q %v", name, err)
+
+	opt.UnaryInterceptor = HMACInterceptor("bob", []byte("wrong key"))
+	forged, err := Dial("tcp", addr, &opt)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = forged.Close() }()
+	err = forged.Call(context.Background(), "Account.Name", 0, &name)
+	_assert(status.Code(err) == codes.Unauthenticated, "expect Unauthenticated for a bad signature, got %v", err)
+
+	// a signature for another method, other arguments, too old or replayed is rejected
+	plain, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = plain.Close() }()
+	sign := func(serviceMethod string, args interface{}, t time.Time) string {
+		auth, err := SignHMAC("bob", hmacKey, serviceMethod, args, t)
+		_assert(err == nil, "sign: %v", err)
+		return auth
+	}
+	call := func(auth string) error {
+		return plain.Call(metadata.AppendToOutgoingContext(context.Background(), AuthorizationKey, auth), "Account.Name", 0, &name)
+	}
+	for _, auth := range []string{
+		sign("Account.Secret", 0, time.Now()),
+		sign("Account.Name", 1, time.Now()),
+		sign("Account.Name", 0, time.Now().Add(-time.Hour)),
+		"HMAC bob",
+	} {
+		err = call(auth)
+		_assert(status.Code(err) == codes.Unauthenticated, "%s: expect Unauthenticated, got %v", auth, err)
+	}
+	auth := sign("Account.Name", 0, time.Now())
+	err = call(auth)
+	_assert(err == nil && name == "bob", "expect bob, got %q %v", name, err)
+	err = call(auth)
+	_assert(status.Code(err) == codes.Unauthenticated, "expect Unauthenticated for a replay, got %v", err)
+}
+
+func TestServer_GatewayAuthentication(t *testing.T) {
+	server, _ := newAuthServer()
+	ts := httptest.NewServer(server.GatewayHandler(DefaultGatewayPath))
+	defer ts.Close()
+
+	for auth, expect := range map[string]int{
+		"":             http.StatusUnauthorized,
+		"Bearer tok-a": http.StatusForbidden,
+		"Bearer tok-r": http.StatusOK,
+	} {
+		req, err := http.NewRequest("POST", ts.URL+DefaultGatewayPath+"Account.Secret", strings.NewReader("0"))
+		_assert(err == nil, "new request: %v", err)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		_assert(err == nil, "post: %v", err)
+		_ = resp.Body.Close()
+		_assert(resp.StatusCode == expect, "%q: expect HTTP %d, got %s", auth, expect, resp.Status)
+	}
+}
+
+func TestACL(t *testing.T) {
+	acl := NewACL().AllowAnonymous("Health.Check").Allow("*", "Arith.*").Allow("root", "*")
+	for _, c := range []struct {
+		principal     *Principal
+		serviceMethod string
+		allowed       bool
+	}{
+		{nil, "Health.Check", true},
+		{nil, "Arith.Mul", false},
+		{&Principal{Name: "alice"}, "Arith.Mul", true},
+		{&Principal{Name: "alice"}, "Admin.Drop", false},
+		{&Principal{Name: "root"}, "Admin.Drop", true},
+	} {
+		_assert(acl.Allowed(c.principal, c.serviceMethod) == c.allowed, "%+v %s: expect %v", c.principal, c.serviceMethod, c.allowed)
+	}
+}
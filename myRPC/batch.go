@@ -0,0 +1,235 @@
+package myRPC
+
+import (
+	"context"
+	"errors"
+	"log"
+	"rpc/myRPC/codec"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/metadata"
+	"rpc/myRPC/status"
+	"sync/atomic"
+	"time"
+)
+
+// Batch invokes calls in a single round trip. The requests are written
+// together, the server runs them concurrently and writes all replies together.
+// Fill in ServiceMethod, Args and Reply of each call, and Metadata to send
+// other metadata than the outgoing metadata of ctx. Batch waits for all calls,
+// the result of each one is in its Error and Reply.
+//
+// The returned error is set if the batch fails as a whole: the client is shut
+// down, the batch can not be written or ctx is done before all replies arrive.
+// The unary interceptor of the client is not applied to the calls.
+func (client *Client) Batch(ctx context.Context, calls []*Call) error {
+	return client.batch(ctx, calls, codec.KindBatch)
+}
+
+// BatchInOrder is like Batch but the server runs the calls one after
+// another in the order of calls.
+func (client *Client) BatchInOrder(ctx context.Context, calls []*Call) error {
+	return client.batch(ctx, calls, codec.KindBatchInOrder)
+}
+
+func (client *Client) batch(ctx context.Context, calls []*Call, kind codec.Kind) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	// tell the server how long we are going to wait
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			return status.Error(codes.DeadlineExceeded, "rpc: Batch: "+context.DeadlineExceeded.Error())
+		}
+	}
+	for _, call := range calls {
+		call.Error, call.Trailer = nil, nil
+		call.Done = make(chan *Call, 1)
+		call.timeout = timeout
+		if call.Metadata == nil {
+			call.Metadata = md
+		}
+	}
+
+	if err := client.sendBatch(calls, kind); err != nil {
+		for _, call := range calls {
+			call.Error = err
+		}
+		return err
+	}
+	for i, call := range calls {
+		select {
+		case <-call.Done:
+		case <-ctx.Done():
+			err := status.Error(status.FromContextError(ctx.Err()).Code(), "rpc: Batch: "+ctx.Err().Error())
+			for _, call := range calls[i:] {
+				if client.removeCall(call.Seq) == nil {
+					// the reply is being read
+					<-call.Done
+					continue
+				}
+				// still running on the server, tell it to stop
+				client.sendCancel(call.Seq)
+				call.Error = err
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// sendBatch registers calls and writes them as one batch.
+func (client *Client) sendBatch(calls []*Call, kind codec.Kind) error {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+
+	hs := make([]*codec.Header, len(calls))
+	bodies := make([]interface{}, len(calls))
+	for i, call := range calls {
+		if _, err := client.register(call); err != nil {
+			for _, call := range calls[:i] {
+				client.removeCall(call.Seq)
+			}
+			return err
+		}
+		hs[i] = &codec.Header{
+			ServiceMethod: call.ServiceMethod,
+			Seq:           call.Seq,
+			Kind:          kind,
+			Timeout:       call.timeout,
+			Metadata:      call.Metadata,
+		}
+		bodies[i] = call.Args
+	}
+	hs[0].Batch = uint32(len(calls))
+
+	if err := codec.WriteBatch(client.cc, hs, bodies); err != nil {
+		for _, call := range calls {
+			client.removeCall(call.Seq)
+		}
+		return err
+	}
+	return nil
+}
+
+func isBatch(kind codec.Kind) bool {
+	return kind == codec.KindBatch || kind == codec.KindBatchInOrder
+}
+
+var errBadBatch = errors.New("rpc server: malformed batch")
+
+// batch is a batch of requests read together, errs[i] is the error
+// reading reqs[i], which is answered with it instead of being handled.
+type batch struct {
+	inOrder bool
+	reqs    []*request
+	errs    []error
+}
+
+// add adds req to b. The requests of a batch are handled and answered
+// like calls, except that the replies are written together.
+func (b *batch) add(req *request, err error) {
+	req.h.Kind = codec.KindCall
+	req.h.Batch = 0
+	b.reqs = append(b.reqs, req)
+	b.errs = append(b.errs, err)
+}
+
+// fail answers all requests of b with err.
+func (b *batch) fail(err error) {
+	for i := range b.errs {
+		b.errs[i] = err
+	}
+}
+
+// readBatch reads the rest of the batch whose first request is first,
+// err is the error reading first. It returns an error only if the
+// connection can not be read any more.
+func (server *Server) readBatch(cc codec.Codec, first *request, err error) (*batch, error) {
+	n := int(first.h.Batch)
+	if n == 0 {
+		return nil, errBadBatch
+	}
+	b := &batch{inOrder: first.h.Kind == codec.KindBatchInOrder}
+	b.add(first, err)
+	for len(b.reqs) < n {
+		req, err := server.readRequest(cc)
+		if req == nil {
+			return nil, err
+		}
+		if !isBatch(req.h.Kind) {
+			return nil, errBadBatch
+		}
+		b.add(req, err)
+	}
+	return b, nil
+}
+
+// handleBatch starts the requests of b and writes their replies together
+// once they are all done. The requests are served like calls, each on a
+// goroutine or a worker of its own, the requests of an in-order batch one
+// after another.
+func (server *Server) handleBatch(sc *serverConn, cc codec.Codec, b *batch, timeout time.Duration) {
+	bodies := make([]interface{}, len(b.reqs))
+	pending := int32(len(b.reqs))
+	var start func(i int)
+	done := func(i int) func(body interface{}, ok bool) {
+		return func(body interface{}, ok bool) {
+			if ok {
+				bodies[i] = body
+			}
+			if b.inOrder && i+1 < len(b.reqs) {
+				start(i + 1)
+			}
+			if atomic.AddInt32(&pending, -1) == 0 {
+				server.writeBatch(sc, cc, b, bodies)
+				sc.endRequest()
+			}
+		}
+	}
+	start = func(i int) {
+		req := b.reqs[i]
+		if err := b.errs[i]; err != nil {
+			sc.releaseRequest(req)
+			status.Convert(err).ToHeader(req.h)
+			done(i)(invalidRequest, true)
+			return
+		}
+		server.startRequest(sc, req, timeout, done(i))
+	}
+	if b.inOrder {
+		start(0)
+		return
+	}
+	for i := range b.reqs {
+		start(i)
+	}
+}
+
+// writeBatch writes the replies of b together, bodies[i] is the body of the
+// reply to b.reqs[i], nil if it is not answered.
+func (server *Server) writeBatch(sc *serverConn, cc codec.Codec, b *batch, bodies []interface{}) {
+	hs := make([]*codec.Header, 0, len(b.reqs))
+	replies := make([]interface{}, 0, len(b.reqs))
+	for i, req := range b.reqs {
+		if bodies[i] != nil {
+			hs = append(hs, req.h)
+			replies = append(replies, bodies[i])
+		}
+	}
+	if len(hs) == 0 {
+		return
+	}
+	sc.sending.Lock()
+	defer sc.sending.Unlock()
+	if err := codec.WriteBatch(cc, hs, replies); err != nil {
+		log.Println("rpc server: write batch response error:", err)
+		// a reply that can not be encoded fails the whole batch,
+		// don't let it take the other replies with it
+		for i, h := range hs {
+			writeResponse(cc, h, replies[i])
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package myRPC
+
+import (
+	"context"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/status"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Tally records the order its calls finish in.
+type Tally struct {
+	mu    sync.Mutex
+	order []int
+}
+
+func (t *Tally) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	t.mu.Lock()
+	t.order = append(t.order, ms)
+	t.mu.Unlock()
+	*reply = ms
+	return nil
+}
+
+func (t *Tally) Fail(ms int, reply *int) error {
+	return status.Errorf(codes.FailedPrecondition, "tally: fail %d", ms)
+}
+
+func (t *Tally) reset() []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	order := t.order
+	t.order = nil
+	return order
+}
+
+func TestClient_Batch(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, new(Tally))
+	for _, opt := range codecOptions() {
+		client, err := Dial("tcp", addr, &opt)
+		_assert(err == nil, "dial: %v", err)
+
+		replies := make([]int, 4)
+		calls := []*Call{
+			{ServiceMethod: "Tally.Sleep", Args: 1, Reply: &replies[0]},
+			{ServiceMethod: "Tally.Fail", Args: 2, Reply: &replies[1]},
+			{ServiceMethod: "Tally.Nope", Args: 3, Reply: &replies[2]},
+			{ServiceMethod: "Tally.Sleep", Args: 4, Reply: &replies[3]},
+		}
+		err = client.Batch(context.Background(), calls)
+		_assert(err == nil, "%s: batch: %v", opt.CodeType, err)
+		_assert(calls[0].Error == nil && replies[0] == 1, "%s: expect 1, got %d %v", opt.CodeType, replies[0], calls[0].Error)
+		_assert(status.Code(calls[1].Error) == codes.FailedPrecondition && calls[1].Error.Error() == "tally: fail 2",
+			"%s: expect FailedPrecondition, got %v", opt.CodeType, calls[1].Error)
+		_assert(status.Code(calls[2].Error) == codes.Unimplemented, "%s: expect Unimplemented, got %v", opt.CodeType, calls[2].Error)
+		_assert(calls[3].Error == nil && replies[3] == 4, "%s: expect 4, got %d %v", opt.CodeType, replies[3], calls[3].Error)
+
+		// the client still works after a batch
+		var reply int
+		_assert(client.Call(context.Background(), "Tally.Sleep", 5, &reply) == nil && reply == 5, "%s: call after batch", opt.CodeType)
+		_ = client.Close()
+		_assert(client.Batch(context.Background(), calls) == ErrShutdown, "%s: expect ErrShutdown after Close", opt.CodeType)
+		_assert(calls[0].Error == ErrShutdown, "%s: expect the calls to fail with ErrShutdown", opt.CodeType)
+	}
+}
+
+func TestClient_BatchOrder(t *testing.T) {
+	tally := new(Tally)
+	addr := startTestServer(t, NewServer(), (*Server).Accept, tally)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	newCalls := func() []*Call {
+		return []*Call{
+			{ServiceMethod: "Tally.Sleep", Args: 100, Reply: new(int)},
+			{ServiceMethod: "Tally.Sleep", Args: 0, Reply: new(int)},
+		}
+	}
+	_assert(client.Batch(context.Background(), newCalls()) == nil, "batch")
+	order := tally.reset()
+	_assert(len(order) == 2 && order[0] == 0 && order[1] == 100, "expect concurrent calls to finish as [0 100], got %v", order)
+
+	_assert(client.BatchInOrder(context.Background(), newCalls()) == nil, "batch in order")
+	order = tally.reset()
+	_assert(len(order) == 2 && order[0] == 100 && order[1] == 0, "expect calls in order to finish as [100 0], got %v", order)
+}
+
+func TestClient_BatchTimeout(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, new(Tally))
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	calls := []*Call{
+		{ServiceMethod: "Tally.Sleep", Args: 0, Reply: new(int)},
+		{ServiceMethod: "Tally.Sleep", Args: 500, Reply: new(int)},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = client.BatchInOrder(ctx, calls)
+	_assert(status.Code(err) == codes.DeadlineExceeded, "expect DeadlineExceeded, got %v", err)
+	// the replies are written together, so the first one is not back either
+	for i, call := range calls {
+		_assert(status.Code(call.Error) == codes.DeadlineExceeded, "call %d: expect DeadlineExceeded, got %v", i, call.Error)
+	}
+
+	client.mu.Lock()
+	pending := len(client.pending)
+	client.mu.Unlock()
+	_assert(pending == 0, "calls should not be pending, got %d", pending)
+}
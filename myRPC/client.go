@@ -3,6 +3,7 @@ package myRPC
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"io"
@@ -10,11 +11,16 @@ import (
 	"net"
 	"net/http"
 	"rpc/myRPC/codec"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/metadata"
+	"rpc/myRPC/status"
 	"sync"
 	"time"
 )
 
-var ErrShutdown = errors.New("connection is shut down")
+// ErrShutdown is returned for calls on a client that is closed or whose
+// server has gone away, it has code Unavailable.
+var ErrShutdown = status.Error(codes.Unavailable, "connection is shut down")
 
 // Call represents an active RPC.
 type Call struct {
@@ -24,6 +30,10 @@ type Call struct {
 	Reply         interface{} // The reply from the function (*struct).
 	Error         error       // After completion, the error status.
 	Done          chan *Call  // Receives *Call when Go is complete.
+	Metadata      metadata.MD // The metadata sent with the request.
+	Trailer       metadata.MD // The trailer metadata of the reply.
+
+	timeout time.Duration // time left before the caller's deadline, 0 means none
 }
 
 func (call *Call) done() {
@@ -42,11 +52,14 @@ type Client struct {
 	cc codec.Codec
 
 	//opt     *Option // set option,i think it may necessary
-	sending sync.Mutex // using in send()
+	sending     sync.Mutex // using in send()
+	interceptor UnaryClientInterceptor
+	cancels     bool // the server understands cancel frames, see Option.BinaryHandshake
 
 	mu       sync.Mutex
 	seq      uint64
 	pending  map[uint64]*Call
+	streams  map[uint64]*ClientStream
 	closing  bool // user has called Close
 	shutdown bool // server has told us to stop
 }
@@ -107,6 +120,11 @@ func (client *Client) terminalCall(err error) {
 		call.Error = err
 		call.done()
 	}
+	for seq, cs := range client.streams {
+		delete(client.streams, seq)
+		close(cs.done)
+		cs.s.abort(err)
+	}
 }
 
 // receive receive reply from server
@@ -120,6 +138,22 @@ func (client *Client) receive() {
 			break
 		}
 
+		if h.Kind == codec.KindGoAway {
+			// The server is shutting down. Calls in flight are still
+			// answered, but new calls must go to another server.
+			client.mu.Lock()
+			client.shutdown = true
+			client.mu.Unlock()
+			err = client.cc.ReadBody(nil)
+			continue
+		}
+
+		switch h.Kind {
+		case codec.KindStreamMsg, codec.KindStreamEnd, codec.KindWindowUpdate:
+			err = client.receiveStream(&h)
+			continue
+		}
+
 		call := client.removeCall(h.Seq)
 		switch {
 		case call == nil:
@@ -132,26 +166,28 @@ func (client *Client) receive() {
 			if err != nil {
 				err = errors.New("receive: reading body: " + err.Error())
 			}
-		case h.Error != "":
+		case h.Error != "" || h.Code != 0:
+			call.Trailer = h.Metadata
 			// We've got an error response. Give this to the request;
 			// any subsequent requests will get the ReadResponseBody
 			// error if there is one.
-			call.Error = errors.New(h.Error)
+			call.Error = status.FromHeader(&h).Err()
 			err = client.cc.ReadBody(nil)
 			if err != nil {
 				err = errors.New("receive: reading body: " + err.Error())
 			}
 			call.done()
 		default:
+			call.Trailer = h.Metadata
 			err = client.cc.ReadBody(call.Reply)
 			if err != nil {
-				call.Error = errors.New("receive: reading body " + err.Error())
+				call.Error = status.Error(codes.Internal, "receive: reading body "+err.Error())
 			}
 			call.done()
 		}
 	}
-	// Terminate pending calls.
-	client.terminalCall(err)
+	// Terminate pending calls, they may be retried on another connection.
+	client.terminalCall(status.Error(codes.Unavailable, err.Error()))
 }
 
 // NewClient return a new client with default option
@@ -160,27 +196,48 @@ func NewClient(conn net.Conn) (*Client, error) {
 }
 
 func NewClientWithOption(conn net.Conn, opt *Option) (*Client, error) {
-	f := codec.NewCodeFuncMap[opt.CodeType]
-	if f == nil {
+	if codec.NewCodeFuncMap[opt.CodeType] == nil {
 		return nil, errors.New("unknown code type")
 	}
+	if !codec.IsValidCompressType(opt.Compression) {
+		return nil, errors.New("unknown compress type")
+	}
 
-	err := json.NewEncoder(conn).Encode(opt)
+	var err error
+	if opt.BinaryHandshake {
+		err = writeHandshake(conn, opt)
+	} else {
+		err = json.NewEncoder(conn).Encode(opt)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cc, err := newCodec(conn, opt, 0)
 	if err != nil {
 		return nil, err
 	}
+	return newClientCodec(cc, opt.UnaryInterceptor, opt.BinaryHandshake), nil
+}
+
+// newClientCodec returns a client sending calls with cc, the handshake is done.
+// cancels tells whether the server understands cancel frames.
+func newClientCodec(cc codec.Codec, interceptor UnaryClientInterceptor, cancels bool) *Client {
 	newClient := &Client{
-		cc:       f(conn),
+		cc:       cc,
 		sending:  sync.Mutex{},
 		mu:       sync.Mutex{},
 		seq:      1,
 		pending:  map[uint64]*Call{},
+		streams:  map[uint64]*ClientStream{},
 		closing:  false,
 		shutdown: false,
+
+		interceptor: interceptor,
+		cancels:     cancels,
 	}
 	// start goroutine to receive reply from server
 	go newClient.receive()
-	return newClient, nil
+	return newClient
 }
 
 // Dial connects to an RPC server at the specified network address.
@@ -202,7 +259,6 @@ func Dial(network, address string, opts ...*Option) (*Client, error) {
 
 // dialWithTimeout connects to an RPC server and with timeout
 func dialWithTimeout(network, address string, opts ...*Option) (client *Client, err error) {
-	var conn net.Conn
 	var tmpOpt *Option
 
 	if len(opts) == 0 {
@@ -214,34 +270,47 @@ func dialWithTimeout(network, address string, opts ...*Option) (client *Client,
 		return
 	}
 
-	// if connection is successful, send struct{}{}
-	ch := make(chan struct{}, 1)
+	// try to connection with timeout
+	conn, err := net.DialTimeout(network, address, tmpOpt.ConnectionTimeout)
+	if err != nil {
+		return nil, err
+	}
 
+	type result struct {
+		client *Client
+		err    error
+	}
+	if tmpOpt.TLSConfig != nil {
+		conn = clientTLS(conn, tmpOpt.TLSConfig, address)
+	}
+	// buffered, so the goroutine can exit after a timeout
+	ch := make(chan result, 1)
 	go func() {
-		// try to connection with timeout
-		conn, err = net.DialTimeout(network, address, tmpOpt.ConnectionTimeout)
-		ch <- struct{}{}
+		if err := handshakeTLS(conn, tmpOpt.ConnectionTimeout); err != nil {
+			ch <- result{nil, err}
+			return
+		}
+		client, err := NewClientWithOption(conn, tmpOpt)
+		ch <- result{client, err}
 	}()
 
-	defer func() {
-		if err != nil {
+	if tmpOpt.ConnectionTimeout == 0 {
+		r := <-ch
+		if r.err != nil {
 			_ = conn.Close()
 		}
-	}()
-
-	if err != nil {
-		return
+		return r.client, r.err
 	}
-
 	select {
 	case <-time.After(tmpOpt.ConnectionTimeout):
-		err = errors.New("rpc: connect timeout")
-		return
-	case <-ch:
-		client, err = NewClientWithOption(conn, tmpOpt)
-		return
+		_ = conn.Close()
+		return nil, errors.New("rpc: connect timeout")
+	case r := <-ch:
+		if r.err != nil {
+			_ = conn.Close()
+		}
+		return r.client, r.err
 	}
-
 }
 
 func (client *Client) send(call *Call) {
@@ -258,6 +327,8 @@ func (client *Client) send(call *Call) {
 	var h codec.Header
 	h.Seq = seq
 	h.ServiceMethod = call.ServiceMethod
+	h.Timeout = call.timeout
+	h.Metadata = call.Metadata
 
 	err = client.cc.Write(&h, call.Args)
 	if err != nil {
@@ -269,6 +340,37 @@ func (client *Client) send(call *Call) {
 	}
 }
 
+// sendCancel tells the server to stop handling the call with seq. Servers
+// that predate cancel frames close the connection on them, so without the
+// binary handshake the call is only dropped by the client.
+func (client *Client) sendCancel(seq uint64) {
+	if !client.cancels {
+		return
+	}
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	h := codec.Header{Seq: seq, Kind: codec.KindCancel}
+	if err := client.cc.Write(&h, struct{}{}); err != nil {
+		log.Println("rpc: send cancel: err:", err)
+	}
+}
+
+// Notify invokes the named function without waiting for it, the server sends
+// no reply. It returns once the request is written, an error of the function
+// is only logged by the server.
+func (client *Client) Notify(serviceMethod string, args interface{}) error {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	client.mu.Lock()
+	closed := client.closing || client.shutdown
+	client.mu.Unlock()
+	if closed {
+		return ErrShutdown
+	}
+	h := codec.Header{ServiceMethod: serviceMethod, Kind: codec.KindNotify}
+	return client.cc.Write(&h, args)
+}
+
 // Go invokes the function asynchronously. It returns the Call structure representing
 // the invocation. The done channel will signal when the call is complete by returning
 // the same Call object. If done is nil, Go will allocate a new channel.
@@ -282,43 +384,94 @@ func (client *Client) Go(serviceMethod string, args interface{}, reply interface
 		done = make(chan *Call, 1)
 	}
 	call.Done = done
+	if client.interceptor != nil {
+		go func() {
+			call.Error = client.interceptor(context.Background(), serviceMethod, args, reply, client.invoke)
+			call.done()
+		}()
+		return call
+	}
 	client.send(call)
 	return call
 }
 
 // Call invokes the named function, waits for it to complete, and returns its error status.
 func (client *Client) Call(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	if client.interceptor != nil {
+		return client.interceptor(ctx, serviceMethod, args, reply, client.invoke)
+	}
+	return client.invoke(ctx, serviceMethod, args, reply)
+}
+
+// invoke sends the call and waits for it, it is the UnaryInvoker of the client's interceptor.
+func (client *Client) invoke(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	call := &Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Done: make(chan *Call, 1)}
+	call.Metadata, _ = metadata.FromOutgoingContext(ctx)
+	// tell the server how long we are going to wait
+	if deadline, ok := ctx.Deadline(); ok {
+		call.timeout = time.Until(deadline)
+		if call.timeout <= 0 {
+			return status.Error(codes.DeadlineExceeded, "rpc: Call: "+context.DeadlineExceeded.Error())
+		}
+	}
+	client.send(call)
 	select {
 	case <-ctx.Done():
-		client.removeCall(call.Seq)
-		return errors.New("rpc: Call: " + ctx.Err().Error())
+		if client.removeCall(call.Seq) != nil {
+			// still running on the server, tell it to stop
+			client.sendCancel(call.Seq)
+		}
+		return status.Error(status.FromContextError(ctx.Err()).Code(), "rpc: Call: "+ctx.Err().Error())
 	case <-call.Done:
+		if md, ok := ctx.Value(trailerOutKey{}).(*metadata.MD); ok {
+			*md = call.Trailer
+		}
 		return call.Error
 	}
 }
 
 // DialHTTP connects to an HTTP RPC server at the specified network address
 // listening on the default HTTP RPC path.
-func DialHTTP(network, address string) (*Client, error) {
-	return DialHTTPPath(network, address, DefaultRPCPath)
+func DialHTTP(network, address string, opts ...*Option) (*Client, error) {
+	return DialHTTPPath(network, address, DefaultRPCPath, opts...)
 }
 
 // DialHTTPPath connects to an HTTP RPC server
 // at the specified network address and path.
-func DialHTTPPath(network, address, path string) (*Client, error) {
+// An HTTPS server is dialed with the TLSConfig of the option.
+func DialHTTPPath(network, address, path string, opts ...*Option) (*Client, error) {
+	var opt *Option
+	switch len(opts) {
+	case 0:
+		opt = &DefaultOption
+	case 1:
+		opt = opts[0]
+	default:
+		return nil, errors.New("opts is too much,need one")
+	}
+	return dialHTTPPath(network, address, path, opt.TLSConfig, func(conn net.Conn) (*Client, error) {
+		return NewClientWithOption(conn, opt)
+	})
+}
+
+// dialHTTPPath connects to an HTTP RPC server at path, secured with
+// tlsConfig if not nil, and creates the client on the connection with newClient.
+func dialHTTPPath(network, address, path string, tlsConfig *tls.Config, newClient func(net.Conn) (*Client, error)) (*Client, error) {
 	var err error
 	conn, err := net.Dial(network, address)
 	if err != nil {
 		return nil, err
 	}
+	if tlsConfig != nil {
+		conn = clientTLS(conn, tlsConfig, address)
+	}
 	io.WriteString(conn, "CONNECT "+path+" HTTP/1.0\n\n")
 
 	// Require successful HTTP response
 	// before switching to RPC protocol.
 	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
 	if err == nil && resp.Status == connected {
-		client, err := NewClient(conn)
+		client, err := newClient(conn)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,123 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"google.golang.org/protobuf/runtime/protoimpl"
+)
+
+// BodyCodec 只负责编码 body, 消息的切分由 FrameCodec 完成
+type BodyCodec interface {
+	Marshal(body interface{}) ([]byte, error)
+	Unmarshal(data []byte, body interface{}) error
+}
+
+// 帧头中的 codec id, 一经分配不能修改
+const (
+	jsonID     uint8 = 1
+	gobID      uint8 = 2
+	protobufID uint8 = 3
+	msgpackID  uint8 = 4
+)
+
+var codecIDs = map[Type]uint8{
+	JsonType:     jsonID,
+	GobType:      gobID,
+	ProtobufType: protobufID,
+	MsgpackType:  msgpackID,
+}
+
+var bodyCodecs = map[uint8]BodyCodec{
+	jsonID:     jsonBody{},
+	gobID:      gobBody{},
+	protobufID: protobufBody{},
+	msgpackID:  msgpackBody{},
+}
+
+// CodecID 返回 t 在帧头中的编号
+func CodecID(t Type) (uint8, bool) {
+	id, ok := codecIDs[t]
+	return id, ok
+}
+
+// CodecType 返回编号 id 对应的 Type
+func CodecType(id uint8) (Type, bool) {
+	for t, i := range codecIDs {
+		if i == id {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+type jsonBody struct{}
+
+func (jsonBody) Marshal(body interface{}) ([]byte, error) {
+	return json.Marshal(body)
+}
+
+func (jsonBody) Unmarshal(data []byte, body interface{}) error {
+	return json.Unmarshal(data, body)
+}
+
+// gobBody 每个 body 都使用新的 gob.Encoder, 所以每条消息都带有类型信息,
+// 这样才能在不解码的情况下跳过任意一条消息
+type gobBody struct{}
+
+func (gobBody) Marshal(body interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(body); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobBody) Unmarshal(data []byte, body interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(body)
+}
+
+type protobufBody struct{}
+
+// protoMessage 返回 body 对应的 proto.Message
+// 旧版 protoc-gen-go 生成的消息没有实现 ProtoReflect, 包装后再使用
+func protoMessage(body interface{}) (proto.Message, bool) {
+	switch m := body.(type) {
+	case proto.Message:
+		return m, true
+	case protoiface.MessageV1:
+		return protoimpl.X.ProtoMessageV2Of(m), true
+	}
+	return nil, false
+}
+
+func (protobufBody) Marshal(body interface{}) ([]byte, error) {
+	m, ok := protoMessage(body)
+	if !ok {
+		return nil, fmt.Errorf("codec protobuf: %T does not implement proto.Message", body)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufBody) Unmarshal(data []byte, body interface{}) error {
+	m, ok := protoMessage(body)
+	if !ok {
+		return fmt.Errorf("codec protobuf: %T does not implement proto.Message", body)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+type msgpackBody struct{}
+
+func (msgpackBody) Marshal(body interface{}) ([]byte, error) {
+	return msgpack.Marshal(body)
+}
+
+func (msgpackBody) Unmarshal(data []byte, body interface{}) error {
+	return msgpack.Unmarshal(data, body)
+}
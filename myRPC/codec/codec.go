@@ -1,6 +1,9 @@
 package codec
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 // Header 请求和响应的头部信息
 type Header struct {
@@ -11,8 +14,49 @@ type Header struct {
 	Seq uint64
 	// 错误信息
 	Error string
+	// 消息类型, 零值表示普通的请求和响应
+	Kind Kind `json:",omitempty" msgpack:",omitempty"`
+	// 请求发出时调用方剩余的超时时间, 0 表示没有限制
+	Timeout time.Duration `json:",omitempty" msgpack:",omitempty"`
+	// 请求携带的元数据, 响应中为服务端设置的 trailer
+	Metadata map[string]string `json:",omitempty" msgpack:",omitempty"`
+	// 错误的状态码, 取值见 codes 包, 0 表示成功
+	Code uint32 `json:",omitempty" msgpack:",omitempty"`
+	// 错误的详细信息
+	Details []Detail `json:",omitempty" msgpack:",omitempty"`
+	// KindWindowUpdate 消息中允许对端继续发送的流消息个数
+	Window uint32 `json:",omitempty" msgpack:",omitempty"`
+	// 批量请求的条数, 只在一批请求的第一条上设置
+	Batch uint32 `json:",omitempty" msgpack:",omitempty"`
+}
+
+// Detail 是错误附带的一条详细信息, Value 是 Type 类型的值编码后的内容
+type Detail struct {
+	Type  string
+	Value []byte
 }
 
+// Kind 消息类型
+type Kind uint8
+
+const (
+	KindCall   Kind = iota // 普通的请求和响应
+	KindGoAway             // 服务端正在关闭, 客户端不要再发送新的请求
+	KindCancel             // 客户端取消了 Seq 对应的请求, 服务端停止处理且不再响应
+
+	// 流式调用的消息, 流由 Seq 标识, 与普通调用复用同一个连接
+	KindStreamOpen   // 客户端打开一个流, body 是调用的参数
+	KindStreamMsg    // 流中的一条消息
+	KindStreamEnd    // 发送方不再发送消息, 服务端发出时 Error, Code 和 Metadata 是调用的结果
+	KindWindowUpdate // 接收方允许对端再发送 Window 条消息
+
+	KindNotify // 不需要响应的请求, 服务端执行后不发送响应
+
+	// 批量请求, 一批请求连续写出, 服务端读完整批后执行, 所有响应一起写回
+	KindBatch        // 服务端并发执行这一批请求
+	KindBatchInOrder // 服务端按顺序逐个执行这一批请求
+)
+
 // 消息编码解码接口
 type Codec interface {
 	io.Closer
@@ -21,18 +65,49 @@ type Codec interface {
 	Write(*Header, interface{}) error
 }
 
+// BatchWriter 是可以一次写入多条消息的 Codec, 整批消息只 flush 一次
+// 编码失败时不能在链接上留下这一批中的任何消息
+type BatchWriter interface {
+	WriteBatch(hs []*Header, bodies []interface{}) error
+}
+
+// WriteBatch 把 hs 和 bodies 中的消息连续写入 c
+// c 没有实现 BatchWriter 时逐条写入, 写了一部分后失败的话对端会一直等待剩下的消息, 只能关闭链接
+func WriteBatch(c Codec, hs []*Header, bodies []interface{}) error {
+	if bw, ok := c.(BatchWriter); ok {
+		return bw.WriteBatch(hs, bodies)
+	}
+	for i, h := range hs {
+		if err := c.Write(h, bodies[i]); err != nil {
+			if i > 0 {
+				_ = c.Close()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// maxMessageSize 带长度前缀的编码中单条消息的最大长度, 防止对端发来错误的长度导致分配过多内存
+const maxMessageSize = 64 << 20
+
 type NewCodeFunc func(closer io.ReadWriteCloser) Codec
 
 type Type string
 
 const (
-	GobType  Type = "application/gob" // not implemented
-	JsonType Type = "application/json"
+	GobType      Type = "application/gob"
+	JsonType     Type = "application/json"
+	ProtobufType Type = "application/protobuf"
+	MsgpackType  Type = "application/msgpack"
 )
 
 var NewCodeFuncMap map[Type]NewCodeFunc
 
 func init() {
 	NewCodeFuncMap = make(map[Type]NewCodeFunc)
+	NewCodeFuncMap[GobType] = NewGobCodec
 	NewCodeFuncMap[JsonType] = NewJsonCodec
+	NewCodeFuncMap[ProtobufType] = NewProtobufCodec
+	NewCodeFuncMap[MsgpackType] = NewMsgpackCodec
 }
@@ -0,0 +1,134 @@
+package codec
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodec_HeaderFields(t *testing.T) {
+	codecs := make(map[string]Codec)
+	for typ, f := range NewCodeFuncMap {
+		codecs[string(typ)] = f(new(bufferConn))
+	}
+	for _, typ := range []Type{JsonType, ProtobufType} {
+		cc, err := NewFrameCodec(new(bufferConn), FrameOption{CodeType: typ})
+		if err != nil {
+			t.Fatal(err)
+		}
+		codecs["frame "+string(typ)] = cc
+	}
+
+	md := map[string]string{"request-id": "42", "tenant": "a", "empty": ""}
+	details := []Detail{{Type: "example.Detail", Value: []byte(`{"a":1}`)}, {Type: "empty"}}
+	for name, cc := range codecs {
+		if err := cc.Write(&Header{ServiceMethod: "Foo.Bar", Seq: 1, Metadata: md, Error: "oops", Code: 3, Details: details, Window: 16, Batch: 3}, struct{}{}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := cc.Write(&Header{ServiceMethod: "Foo.Bar", Seq: 2}, struct{}{}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		var h Header
+		if err := cc.ReadHeader(&h); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(h.Metadata) != len(md) {
+			t.Fatalf("%s: got metadata %v, expect %v", name, h.Metadata, md)
+		}
+		for k, v := range md {
+			if got, ok := h.Metadata[k]; !ok || got != v {
+				t.Fatalf("%s: got metadata %v, expect %v", name, h.Metadata, md)
+			}
+		}
+		if h.Error != "oops" || h.Code != 3 || h.Window != 16 || h.Batch != 3 || len(h.Details) != 2 ||
+			h.Details[0].Type != details[0].Type || string(h.Details[0].Value) != string(details[0].Value) ||
+			h.Details[1].Type != "empty" || len(h.Details[1].Value) != 0 {
+			t.Fatalf("%s: got %+v", name, h)
+		}
+		if err := cc.ReadBody(nil); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		// a header without metadata must not keep the previous one
+		h = Header{}
+		if err := cc.ReadHeader(&h); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if h.Seq != 2 || len(h.Metadata) != 0 || h.Code != 0 || h.Batch != 0 || len(h.Details) != 0 {
+			t.Fatalf("%s: got %+v", name, h)
+		}
+		if err := cc.ReadBody(nil); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestCodec_WriteBatch(t *testing.T) {
+	for _, typ := range []Type{JsonType, MsgpackType} {
+		conn := new(bufferConn)
+		cc, err := NewFrameCodec(conn, FrameOption{CodeType: typ})
+		if err != nil {
+			t.Fatal(err)
+		}
+		json := NewJsonCodec(new(bufferConn))
+		gob := NewGobCodec(new(bufferConn))
+		for name, cc := range map[string]Codec{"frame " + string(typ): cc, "json": json, "gob": gob} {
+			hs := []*Header{{ServiceMethod: "Foo.Bar", Seq: 1, Kind: KindBatch, Batch: 3}, {Seq: 2, Kind: KindBatch}, {Seq: 3, Kind: KindBatch}}
+			if err := WriteBatch(cc, hs, []interface{}{"a", "b", "c"}); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			for i, expect := range []string{"a", "b", "c"} {
+				var h Header
+				if err := cc.ReadHeader(&h); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				var body string
+				if err := cc.ReadBody(&body); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if h.Seq != uint64(i+1) || h.Kind != KindBatch || body != expect {
+					t.Fatalf("%s: got %+v %q, expect seq %d body %q", name, h, body, i+1, expect)
+				}
+			}
+		}
+
+		// a body that can not be encoded fails the whole batch before anything is written
+		hs := []*Header{{Seq: 1, Batch: 2}, {Seq: 2}}
+		if err := WriteBatch(cc, hs, []interface{}{"a", make(chan int)}); err == nil {
+			t.Fatalf("frame %s: expect error encoding a chan", typ)
+		}
+		if conn.Len() != 0 {
+			t.Fatalf("frame %s: %d bytes written by a failed batch", typ, conn.Len())
+		}
+	}
+}
+
+func TestCodec_Protobuf(t *testing.T) {
+	cc, err := NewFrameCodec(new(bufferConn), FrameOption{CodeType: ProtobufType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codecs := map[string]Codec{"protobuf": NewCodeFuncMap[ProtobufType](new(bufferConn)), "frame protobuf": cc}
+	for name, cc := range codecs {
+		if err := cc.Write(&Header{ServiceMethod: "Foo.Bar", Seq: 1}, wrapperspb.String("hello")); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var h Header
+		if err := cc.ReadHeader(&h); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var body wrapperspb.StringValue
+		if err := cc.ReadBody(&body); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if h.Seq != 1 || body.GetValue() != "hello" {
+			t.Fatalf("%s: got %+v %q", name, h, body.GetValue())
+		}
+
+		// a body that is not a protobuf message is an error
+		if err := cc.Write(&Header{Seq: 2}, "hello"); err == nil {
+			t.Fatalf("%s: expect error encoding a string", name)
+		}
+	}
+}
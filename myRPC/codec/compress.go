@@ -0,0 +1,263 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressType 链接上使用的压缩算法
+type CompressType string
+
+const (
+	CompressNone   CompressType = ""
+	CompressGzip   CompressType = "gzip"
+	CompressSnappy CompressType = "snappy"
+	CompressZstd   CompressType = "zstd"
+)
+
+// DefaultCompressThreshold 小于这个长度的消息不压缩
+const DefaultCompressThreshold = 1024
+
+// compressor 压缩和解压一整条消息
+type compressor interface {
+	compress(src []byte) ([]byte, error)
+	decompress(src []byte) ([]byte, error)
+}
+
+var compressors = map[CompressType]compressor{
+	CompressGzip:   gzipCompressor{},
+	CompressSnappy: snappyCompressor{},
+	CompressZstd:   &zstdCompressor{},
+}
+
+// IsValidCompressType 判断是否支持这种压缩算法
+func IsValidCompressType(t CompressType) bool {
+	if t == CompressNone {
+		return true
+	}
+	_, ok := compressors[t]
+	return ok
+}
+
+// 帧头: 1 字节标志位 + 4 字节大端序的长度
+const (
+	frameHeaderLen = 5
+	flagCompressed = 1 << 0
+)
+
+// 使用编译器来检测 *CompressCodec 是否实现了 Codec 接口
+var _ Codec = (*CompressCodec)(nil)
+
+// CompressCodec 给任意 Codec 加上压缩
+// 内层 Codec 每次 Write 写出的内容 (header + body) 组成一帧,
+// 长度不小于阈值的帧会被压缩, 读取时按帧解压后再交给内层 Codec 解码
+type CompressCodec struct {
+	Codec
+	conn      io.ReadWriteCloser
+	c         compressor
+	threshold int
+
+	r    *bufio.Reader // 从 conn 中读取帧
+	rbuf []byte        // 当前帧还没有被内层 Codec 读走的部分
+	wbuf bytes.Buffer  // 内层 Codec 写出的一帧
+	w    *bufio.Writer
+}
+
+// NewCompressCodec 返回使用 typ 压缩的 Codec
+// threshold 为 0 时使用 DefaultCompressThreshold, 小于 0 时总是压缩
+func NewCompressCodec(conn io.ReadWriteCloser, f NewCodeFunc, typ CompressType, threshold int) (Codec, error) {
+	c, ok := compressors[typ]
+	if !ok {
+		return nil, fmt.Errorf("codec compress: unknown compress type %q", typ)
+	}
+	if threshold == 0 {
+		threshold = DefaultCompressThreshold
+	}
+	cc := &CompressCodec{
+		conn:      conn,
+		c:         c,
+		threshold: threshold,
+		r:         bufio.NewReader(conn),
+		w:         bufio.NewWriter(conn),
+	}
+	cc.Codec = f(frameConn{cc})
+	return cc, nil
+}
+
+func (c *CompressCodec) Close() error {
+	return c.conn.Close()
+}
+
+func (c *CompressCodec) Write(h *Header, body interface{}) error {
+	defer c.wbuf.Reset()
+	if err := c.Codec.Write(h, body); err != nil {
+		return err
+	}
+
+	var flags byte
+	data := c.wbuf.Bytes()
+	if len(data) >= c.threshold {
+		compressed, err := c.c.compress(data)
+		if err != nil {
+			return err
+		}
+		data = compressed
+		flags |= flagCompressed
+	}
+
+	var head [frameHeaderLen]byte
+	head[0] = flags
+	binary.BigEndian.PutUint32(head[1:], uint32(len(data)))
+	if _, err := c.w.Write(head[:]); err != nil {
+		_ = c.Close()
+		return err
+	}
+	if _, err := c.w.Write(data); err != nil {
+		_ = c.Close()
+		return err
+	}
+	if err := c.w.Flush(); err != nil {
+		_ = c.Close()
+		return err
+	}
+	return nil
+}
+
+// readFrame 读取下一帧, 解压后放入 rbuf
+func (c *CompressCodec) readFrame() error {
+	var head [frameHeaderLen]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(head[1:])
+	if n > maxMessageSize {
+		return fmt.Errorf("codec compress: frame too large: %d bytes", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if head[0]&flagCompressed != 0 {
+		var err error
+		if data, err = c.c.decompress(data); err != nil {
+			return err
+		}
+	}
+	c.rbuf = data
+	return nil
+}
+
+// frameConn 是内层 Codec 看到的链接
+type frameConn struct {
+	c *CompressCodec
+}
+
+func (f frameConn) Read(p []byte) (int, error) {
+	for len(f.c.rbuf) == 0 {
+		if err := f.c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, f.c.rbuf)
+	f.c.rbuf = f.c.rbuf[n:]
+	return n, nil
+}
+
+func (f frameConn) Write(p []byte) (int, error) {
+	return f.c.wbuf.Write(p)
+}
+
+func (f frameConn) Close() error {
+	return f.c.Close()
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) decompress(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxMessageSize {
+		return nil, fmt.Errorf("codec compress: decompressed frame too large")
+	}
+	return data, nil
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) compress(src []byte) ([]byte, error) {
+	return snappy.Encode(nil, src), nil
+}
+
+func (snappyCompressor) decompress(src []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(src)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxMessageSize {
+		return nil, fmt.Errorf("codec compress: decompressed frame too large")
+	}
+	return snappy.Decode(nil, src)
+}
+
+// zstdCompressor 所有链接共用一个 Encoder 和 Decoder, EncodeAll 和 DecodeAll 可以并发调用
+type zstdCompressor struct {
+	once sync.Once
+	enc  *zstd.Encoder
+	dec  *zstd.Decoder
+	err  error
+}
+
+func (z *zstdCompressor) init() error {
+	z.once.Do(func() {
+		if z.enc, z.err = zstd.NewWriter(nil); z.err != nil {
+			return
+		}
+		z.dec, z.err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxMessageSize))
+	})
+	return z.err
+}
+
+func (z *zstdCompressor) compress(src []byte) ([]byte, error) {
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+	return z.enc.EncodeAll(src, nil), nil
+}
+
+func (z *zstdCompressor) decompress(src []byte) ([]byte, error) {
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+	return z.dec.DecodeAll(src, nil)
+}
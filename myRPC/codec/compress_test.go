@@ -0,0 +1,48 @@
+package codec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// bufferConn 把写入的内容原样读回来
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (b *bufferConn) Close() error { return nil }
+
+func TestCompressCodec_Threshold(t *testing.T) {
+	conn := new(bufferConn)
+	cc, err := NewCompressCodec(conn, NewJsonCodec, CompressGzip, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	small, large := "ok", strings.Repeat("large body ", 100)
+	for _, body := range []string{small, large} {
+		before := conn.Len()
+		if err := cc.Write(&Header{ServiceMethod: "Foo.Bar", Seq: 1}, body); err != nil {
+			t.Fatal(err)
+		}
+		compressed := conn.Bytes()[before]&flagCompressed != 0
+		if want := len(body) >= 64; compressed != want {
+			t.Fatalf("body of %d bytes: compressed = %v, want %v", len(body), compressed, want)
+		}
+	}
+
+	for _, want := range []string{small, large} {
+		var h Header
+		var body string
+		if err := cc.ReadHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.ReadBody(&body); err != nil {
+			t.Fatal(err)
+		}
+		if h.ServiceMethod != "Foo.Bar" || body != want {
+			t.Fatalf("got %q %q", h.ServiceMethod, body)
+		}
+	}
+}
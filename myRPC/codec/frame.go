@@ -0,0 +1,474 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// 二进制帧格式, 所有整数都是大端序
+//
+//	0      4         5       6       7          8       16           20         24
+//	+------+---------+-------+-------+----------+-------+------------+----------+
+//	| magic| version | flags | codec | reserved |  seq  | header len | body len |
+//	+------+---------+-------+-------+----------+-------+------------+----------+
+//	| header: TLV 编码的 Header 字段 | body: codec id 对应的 BodyCodec 编码 |
+//	+--------------------------------+-------------------------------------+
+//
+// 接收方不需要解码 body 就能知道消息属于哪个请求、调用哪个方法、body 有多大,
+// header 中不认识的 TLV 字段会被跳过, 以后新增字段不会影响旧版本
+const (
+	FrameMagic     uint32 = 0x3bef5c
+	FrameVersion   uint8  = 1
+	FrameHeaderLen        = 24
+)
+
+// 帧头中的标志位
+const (
+	FlagCompressed uint8 = 1 << 0 // body 经过压缩
+	FlagHandshake  uint8 = 1 << 1 // 建立链接时的握手帧
+)
+
+// DefaultMaxFrameSize 默认允许的最大 body 长度
+const DefaultMaxFrameSize = maxMessageSize
+
+// maxHeaderSize header 部分的最大长度, 超过时无法继续解析后面的帧
+const maxHeaderSize = 1 << 20
+
+// ErrFrameTooLarge body 超过了接收方允许的长度, body 已被跳过, 链接仍然可用
+var ErrFrameTooLarge = errors.New("codec frame: frame too large")
+
+// FrameHeader 帧头中的定长部分
+type FrameHeader struct {
+	Version   uint8
+	Flags     uint8
+	CodecID   uint8
+	Seq       uint64
+	HeaderLen uint32
+	BodyLen   uint32
+}
+
+// WriteFrame 写出一个完整的帧, fh 中的 HeaderLen 和 BodyLen 由 header 和 body 的长度决定
+func WriteFrame(w io.Writer, fh FrameHeader, header, body []byte) error {
+	var b [FrameHeaderLen]byte
+	binary.BigEndian.PutUint32(b[0:], FrameMagic)
+	b[4] = fh.Version
+	b[5] = fh.Flags
+	b[6] = fh.CodecID
+	binary.BigEndian.PutUint64(b[8:], fh.Seq)
+	binary.BigEndian.PutUint32(b[16:], uint32(len(header)))
+	binary.BigEndian.PutUint32(b[20:], uint32(len(body)))
+	if _, err := w.Write(b[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// ReadFrameHeader 读取帧头中的定长部分
+func ReadFrameHeader(r io.Reader) (FrameHeader, error) {
+	var b [FrameHeaderLen]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return FrameHeader{}, err
+	}
+	if magic := binary.BigEndian.Uint32(b[0:]); magic != FrameMagic {
+		return FrameHeader{}, fmt.Errorf("codec frame: invalid magic number %#x", magic)
+	}
+	fh := FrameHeader{
+		Version:   b[4],
+		Flags:     b[5],
+		CodecID:   b[6],
+		Seq:       binary.BigEndian.Uint64(b[8:]),
+		HeaderLen: binary.BigEndian.Uint32(b[16:]),
+		BodyLen:   binary.BigEndian.Uint32(b[20:]),
+	}
+	if fh.Version == 0 {
+		return FrameHeader{}, errors.New("codec frame: invalid version 0")
+	}
+	return fh, nil
+}
+
+// ReadFrameBlock 读取长度为 n 的 header, 长度超过限制时返回错误
+func ReadFrameBlock(r io.Reader, n uint32) ([]byte, error) {
+	if n > maxHeaderSize {
+		return nil, fmt.Errorf("codec frame: header too large: %d bytes", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// AppendField 追加一个 TLV 字段: 1 字节 tag, uvarint 编码的长度, 值
+func AppendField(b []byte, tag uint8, v []byte) []byte {
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(v)))
+	b = append(b, tag)
+	b = append(b, size[:n]...)
+	return append(b, v...)
+}
+
+// AppendUvarintField 追加一个值为 uvarint 的 TLV 字段
+func AppendUvarintField(b []byte, tag uint8, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return AppendField(b, tag, buf[:n])
+}
+
+// Uvarint 解码 AppendUvarintField 写入的值
+func Uvarint(v []byte) (uint64, error) {
+	x, n := binary.Uvarint(v)
+	if n <= 0 || n != len(v) {
+		return 0, errors.New("codec frame: malformed varint field")
+	}
+	return x, nil
+}
+
+// RangeFields 依次对每个 TLV 字段调用 f
+func RangeFields(b []byte, f func(tag uint8, v []byte) error) error {
+	for len(b) > 0 {
+		tag := b[0]
+		size, n := binary.Uvarint(b[1:])
+		if n <= 0 || uint64(len(b)-1-n) < size {
+			return errors.New("codec frame: malformed header field")
+		}
+		b = b[1+n:]
+		if err := f(tag, b[:size]); err != nil {
+			return err
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+// Header 字段的 tag
+const (
+	tagServiceMethod uint8 = 1
+	tagError         uint8 = 2
+	tagKind          uint8 = 3
+	tagTimeout       uint8 = 4
+	tagMetadata      uint8 = 5 // 每个键值对一个字段, 值是 key(1) 和 value(2) 两个 TLV 字段
+	tagCode          uint8 = 6
+	tagDetail        uint8 = 7 // 每条详细信息一个字段, 值是 type(1) 和 value(2) 两个 TLV 字段
+	tagWindow        uint8 = 8
+	tagBatch         uint8 = 9
+)
+
+func marshalFrameHeader(h *Header) []byte {
+	var b []byte
+	if h.ServiceMethod != "" {
+		b = AppendField(b, tagServiceMethod, []byte(h.ServiceMethod))
+	}
+	if h.Error != "" {
+		b = AppendField(b, tagError, []byte(h.Error))
+	}
+	if h.Kind != KindCall {
+		b = AppendUvarintField(b, tagKind, uint64(h.Kind))
+	}
+	if h.Timeout != 0 {
+		b = AppendUvarintField(b, tagTimeout, uint64(h.Timeout))
+	}
+	for k, v := range h.Metadata {
+		entry := AppendField(nil, 1, []byte(k))
+		entry = AppendField(entry, 2, []byte(v))
+		b = AppendField(b, tagMetadata, entry)
+	}
+	if h.Code != 0 {
+		b = AppendUvarintField(b, tagCode, uint64(h.Code))
+	}
+	for _, d := range h.Details {
+		entry := AppendField(nil, 1, []byte(d.Type))
+		entry = AppendField(entry, 2, d.Value)
+		b = AppendField(b, tagDetail, entry)
+	}
+	if h.Window != 0 {
+		b = AppendUvarintField(b, tagWindow, uint64(h.Window))
+	}
+	if h.Batch != 0 {
+		b = AppendUvarintField(b, tagBatch, uint64(h.Batch))
+	}
+	return b
+}
+
+func unmarshalFrameHeader(b []byte, h *Header) error {
+	return RangeFields(b, func(tag uint8, v []byte) error {
+		switch tag {
+		case tagServiceMethod:
+			h.ServiceMethod = string(v)
+		case tagError:
+			h.Error = string(v)
+		case tagKind:
+			kind, err := Uvarint(v)
+			if err != nil {
+				return err
+			}
+			h.Kind = Kind(kind)
+		case tagTimeout:
+			timeout, err := Uvarint(v)
+			if err != nil {
+				return err
+			}
+			h.Timeout = time.Duration(timeout)
+		case tagMetadata:
+			var k, val string
+			err := RangeFields(v, func(tag uint8, v []byte) error {
+				switch tag {
+				case 1:
+					k = string(v)
+				case 2:
+					val = string(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if h.Metadata == nil {
+				h.Metadata = make(map[string]string)
+			}
+			h.Metadata[k] = val
+		case tagCode:
+			code, err := Uvarint(v)
+			if err != nil {
+				return err
+			}
+			h.Code = uint32(code)
+		case tagDetail:
+			var d Detail
+			err := RangeFields(v, func(tag uint8, v []byte) error {
+				switch tag {
+				case 1:
+					d.Type = string(v)
+				case 2:
+					d.Value = append([]byte(nil), v...)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			h.Details = append(h.Details, d)
+		case tagWindow:
+			window, err := Uvarint(v)
+			if err != nil {
+				return err
+			}
+			h.Window = uint32(window)
+		case tagBatch:
+			batch, err := Uvarint(v)
+			if err != nil {
+				return err
+			}
+			h.Batch = uint32(batch)
+		}
+		// 不认识的字段直接跳过
+		return nil
+	})
+}
+
+// FrameOption 是 FrameCodec 的配置, 由握手协商得到
+type FrameOption struct {
+	CodeType          Type
+	Compression       CompressType
+	CompressThreshold int // 0 时使用 DefaultCompressThreshold, 小于 0 时总是压缩
+	MaxFrameSize      int // 允许接收的最大 body 长度, 0 时使用 DefaultMaxFrameSize
+}
+
+// 使用编译器来检测 *FrameCodec 是否实现了 Codec 接口
+var _ Codec = (*FrameCodec)(nil)
+var _ BatchWriter = (*FrameCodec)(nil)
+
+// FrameCodec 使用二进制帧传输消息, body 由 FrameOption.CodeType 对应的 BodyCodec 编码
+type FrameCodec struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+	w    *bufio.Writer
+
+	codecID      uint8
+	body         BodyCodec
+	c            compressor
+	threshold    int
+	maxFrameSize int
+
+	frame    FrameHeader // 正在读取的帧
+	bodyLeft bool        // 当前帧的 body 还没有被读走
+}
+
+func NewFrameCodec(conn io.ReadWriteCloser, opt FrameOption) (Codec, error) {
+	id, ok := codecIDs[opt.CodeType]
+	if !ok {
+		return nil, fmt.Errorf("codec frame: unknown code type %q", opt.CodeType)
+	}
+	cc := &FrameCodec{
+		conn:         conn,
+		r:            bufio.NewReader(conn),
+		w:            bufio.NewWriter(conn),
+		codecID:      id,
+		body:         bodyCodecs[id],
+		threshold:    opt.CompressThreshold,
+		maxFrameSize: opt.MaxFrameSize,
+	}
+	if opt.Compression != CompressNone {
+		if cc.c, ok = compressors[opt.Compression]; !ok {
+			return nil, fmt.Errorf("codec frame: unknown compress type %q", opt.Compression)
+		}
+	}
+	if cc.threshold == 0 {
+		cc.threshold = DefaultCompressThreshold
+	}
+	if cc.maxFrameSize <= 0 {
+		cc.maxFrameSize = DefaultMaxFrameSize
+	}
+	return cc, nil
+}
+
+func (c *FrameCodec) Close() error {
+	return c.conn.Close()
+}
+
+func (c *FrameCodec) ReadHeader(h *Header) error {
+	// 上一帧的 body 没有被读取时先跳过它
+	if c.bodyLeft {
+		if err := c.discardBody(); err != nil {
+			return err
+		}
+	}
+	fh, err := ReadFrameHeader(c.r)
+	if err != nil {
+		return err
+	}
+	header, err := ReadFrameBlock(c.r, fh.HeaderLen)
+	if err != nil {
+		return err
+	}
+	c.frame, c.bodyLeft = fh, true
+
+	*h = Header{}
+	if err := unmarshalFrameHeader(header, h); err != nil {
+		return err
+	}
+	h.Seq = fh.Seq
+	return nil
+}
+
+// ReadBody body 为 nil 时不解码直接跳过这条消息
+func (c *FrameCodec) ReadBody(body interface{}) error {
+	if !c.bodyLeft {
+		return errors.New("codec frame: ReadBody called before ReadHeader")
+	}
+	if body == nil {
+		return c.discardBody()
+	}
+	if int64(c.frame.BodyLen) > int64(c.maxFrameSize) {
+		if err := c.discardBody(); err != nil {
+			return err
+		}
+		return ErrFrameTooLarge
+	}
+
+	c.bodyLeft = false
+	data := make([]byte, c.frame.BodyLen)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if c.frame.Flags&FlagCompressed != 0 {
+		if c.c == nil {
+			return errors.New("codec frame: compressed frame on an uncompressed connection")
+		}
+		var err error
+		if data, err = c.c.decompress(data); err != nil {
+			return err
+		}
+	}
+	// 错误响应的 body 为空
+	if len(data) == 0 {
+		return nil
+	}
+	bc, ok := bodyCodecs[c.frame.CodecID]
+	if !ok {
+		return fmt.Errorf("codec frame: unknown codec id %d", c.frame.CodecID)
+	}
+	return bc.Unmarshal(data, body)
+}
+
+func (c *FrameCodec) discardBody() error {
+	c.bodyLeft = false
+	_, err := io.CopyN(ioutil.Discard, c.r, int64(c.frame.BodyLen))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (c *FrameCodec) Write(h *Header, body interface{}) error {
+	return c.WriteBatch([]*Header{h}, []interface{}{body})
+}
+
+// WriteBatch 把整批消息写入链接, 只 flush 一次
+// 先把所有 body 编码好再写入, 编码失败时链接上不会留下这一批中的任何消息
+func (c *FrameCodec) WriteBatch(hs []*Header, bodies []interface{}) error {
+	frames := make([]encodedFrame, len(hs))
+	for i, h := range hs {
+		f, err := c.encode(h, bodies[i])
+		if err != nil {
+			return err
+		}
+		frames[i] = f
+	}
+
+	for _, f := range frames {
+		if err := WriteFrame(c.w, f.fh, f.header, f.body); err != nil {
+			_ = c.Close()
+			return err
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		_ = c.Close()
+		return err
+	}
+	return nil
+}
+
+// encodedFrame 编码好等待写入的一帧
+type encodedFrame struct {
+	fh           FrameHeader
+	header, body []byte
+}
+
+func (c *FrameCodec) encode(h *Header, body interface{}) (encodedFrame, error) {
+	var data []byte
+	switch body.(type) {
+	case nil, struct{}:
+		// 错误响应的 body, 写一个空的 body
+	default:
+		var err error
+		if data, err = c.body.Marshal(body); err != nil {
+			return encodedFrame{}, err
+		}
+	}
+
+	fh := FrameHeader{Version: FrameVersion, CodecID: c.codecID, Seq: h.Seq}
+	if c.c != nil && len(data) > 0 && len(data) >= c.threshold {
+		compressed, err := c.c.compress(data)
+		if err != nil {
+			return encodedFrame{}, err
+		}
+		data = compressed
+		fh.Flags |= FlagCompressed
+	}
+	return encodedFrame{fh: fh, header: marshalFrameHeader(h), body: data}, nil
+}
@@ -0,0 +1,58 @@
+package codec
+
+import (
+	"testing"
+)
+
+func TestFrameCodec_SkipsUnknownFields(t *testing.T) {
+	conn := new(bufferConn)
+	cc, err := NewFrameCodec(conn, FrameOption{CodeType: JsonType})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a frame from a newer peer with a header field this version does not know
+	header := marshalFrameHeader(&Header{ServiceMethod: "Foo.Bar"})
+	header = AppendField(header, 200, []byte("from the future"))
+	body, _ := jsonBody{}.Marshal("hello")
+	fh := FrameHeader{Version: FrameVersion + 1, CodecID: jsonID, Seq: 42}
+	if err := WriteFrame(conn, fh, header, body); err != nil {
+		t.Fatal(err)
+	}
+	// a second frame whose body is skipped without being decoded
+	if err := cc.Write(&Header{ServiceMethod: "Foo.Skip", Seq: 43}, "skipped"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.Write(&Header{ServiceMethod: "Foo.Baz", Seq: 44, Error: "oops"}, struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var h Header
+	var s string
+	if err := cc.ReadHeader(&h); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.ReadBody(&s); err != nil {
+		t.Fatal(err)
+	}
+	if h.ServiceMethod != "Foo.Bar" || h.Seq != 42 || s != "hello" {
+		t.Fatalf("got %+v %q", h, s)
+	}
+
+	if err := cc.ReadHeader(&h); err != nil || h.ServiceMethod != "Foo.Skip" {
+		t.Fatalf("got %+v %v", h, err)
+	}
+	if err := cc.ReadBody(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cc.ReadHeader(&h); err != nil {
+		t.Fatal(err)
+	}
+	if h.ServiceMethod != "Foo.Baz" || h.Seq != 44 || h.Error != "oops" {
+		t.Fatalf("got %+v", h)
+	}
+	if err := cc.ReadBody(nil); err != nil {
+		t.Fatal(err)
+	}
+}
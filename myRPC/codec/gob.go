@@ -0,0 +1,61 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"log"
+)
+
+// 使用编译器来检测 *GobCodec 是否实现了 Codec 接口
+var _ Codec = (*GobCodec)(nil)
+
+type GobCodec struct {
+	conn io.ReadWriteCloser
+	buf  *bufio.Writer
+	enc  *gob.Encoder
+	dec  *gob.Decoder
+}
+
+func NewGobCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriter(conn)
+	return &GobCodec{
+		conn: conn,
+		buf:  buf,
+		enc:  gob.NewEncoder(buf),
+		dec:  gob.NewDecoder(conn),
+	}
+}
+
+func (c *GobCodec) Close() error {
+	return c.conn.Close()
+}
+
+func (c *GobCodec) ReadHeader(h *Header) error {
+	return c.dec.Decode(h)
+}
+
+// ReadBody body 为 nil 时丢弃这条消息
+func (c *GobCodec) ReadBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
+	if err = c.enc.Encode(h); err != nil {
+		// gob 的编码器是有状态的, 写了一半的消息会让对端无法继续解码, 只能关闭链接
+		if c.buf.Flush() == nil {
+			log.Printf("codec gob: gob can not encoding header: %v", err)
+			_ = c.Close()
+		}
+		return err
+	}
+	if err = c.enc.Encode(body); err != nil {
+		// header 已经写入, 对端会一直等待 body
+		if c.buf.Flush() == nil {
+			log.Printf("codec gob: gob can not encoding body: %v", err)
+			_ = c.Close()
+		}
+		return err
+	}
+	return c.buf.Flush()
+}
@@ -2,13 +2,16 @@ package codec
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 )
 
 // 使用编译器来检测 *JsonCodec 是否实现了 Codec 接口
 var _ Codec = (*JsonCodec)(nil)
+var _ BatchWriter = (*JsonCodec)(nil)
 
 type JsonCodec struct {
 	conn io.ReadWriteCloser
@@ -35,7 +38,13 @@ func (c *JsonCodec) ReadHeader(h *Header) error {
 	return c.dec.Decode(h)
 }
 
+// ReadBody body 为 nil 时丢弃这条消息
 func (c *JsonCodec) ReadBody(body interface{}) error {
+	if body == nil {
+		// json.Decoder 不能解码到 nil, 读出来扔掉即可
+		var discard json.RawMessage
+		return c.dec.Decode(&discard)
+	}
 	return c.dec.Decode(body)
 }
 
@@ -59,3 +68,26 @@ func (c *JsonCodec) Write(h *Header, body interface{}) (err error) {
 	}
 	return nil
 }
+
+// WriteBatch 先把整批消息编码到内存中, 全部编码成功后再写入链接并 flush 一次
+func (c *JsonCodec) WriteBatch(hs []*Header, bodies []interface{}) error {
+	var batch bytes.Buffer
+	enc := json.NewEncoder(&batch)
+	for i, h := range hs {
+		if err := enc.Encode(h); err != nil {
+			return fmt.Errorf("codec json: json can not encoding header: %v", err)
+		}
+		if err := enc.Encode(bodies[i]); err != nil {
+			return fmt.Errorf("codec json: json can not encoding body: %v", err)
+		}
+	}
+	if _, err := c.buf.Write(batch.Bytes()); err != nil {
+		_ = c.Close()
+		return err
+	}
+	if err := c.buf.Flush(); err != nil {
+		_ = c.Close()
+		return err
+	}
+	return nil
+}
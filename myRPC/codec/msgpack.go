@@ -0,0 +1,110 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// 使用编译器来检测 *MsgpackCodec 是否实现了 Codec 接口
+var _ Codec = (*MsgpackCodec)(nil)
+
+// MsgpackCodec 使用 MessagePack 编码 Header 和 body
+// 每条消息前面都带有 4 字节大端序的长度, 读取时不依赖解码器去切分数据流,
+// 其他语言的实现只需要按长度读出消息再交给各自的 msgpack 库即可
+type MsgpackCodec struct {
+	conn io.ReadWriteCloser
+	buf  *bufio.Writer
+	r    *bufio.Reader
+}
+
+func NewMsgpackCodec(conn io.ReadWriteCloser) Codec {
+	return &MsgpackCodec{
+		conn: conn,
+		buf:  bufio.NewWriter(conn),
+		r:    bufio.NewReader(conn),
+	}
+}
+
+func (c *MsgpackCodec) Close() error {
+	return c.conn.Close()
+}
+
+func (c *MsgpackCodec) ReadHeader(h *Header) error {
+	data, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+	*h = Header{}
+	return msgpack.Unmarshal(data, h)
+}
+
+// ReadBody body 为 nil 时丢弃这条消息
+func (c *MsgpackCodec) ReadBody(body interface{}) error {
+	data, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		return nil
+	}
+	return msgpack.Unmarshal(data, body)
+}
+
+func (c *MsgpackCodec) Write(h *Header, body interface{}) error {
+	// 先把 header 和 body 都编码好再写入, 编码失败时链接上不会留下半条消息
+	header, err := msgpack.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("codec msgpack: can not encoding header: %v", err)
+	}
+	data, err := msgpack.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("codec msgpack: can not encoding body: %v", err)
+	}
+
+	if err := c.writeFrame(header); err != nil {
+		_ = c.Close()
+		return err
+	}
+	if err := c.writeFrame(data); err != nil {
+		_ = c.Close()
+		return err
+	}
+	if err := c.buf.Flush(); err != nil {
+		_ = c.Close()
+		return err
+	}
+	return nil
+}
+
+func (c *MsgpackCodec) writeFrame(data []byte) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+	if _, err := c.buf.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := c.buf.Write(data)
+	return err
+}
+
+func (c *MsgpackCodec) readFrame() ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxMessageSize {
+		return nil, fmt.Errorf("codec msgpack: message too large: %d bytes", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,311 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// 使用编译器来检测 *ProtobufCodec 是否实现了 Codec 接口
+var _ Codec = (*ProtobufCodec)(nil)
+
+// Header 在 protobuf 中的字段编号
+const (
+	headerServiceMethod protowire.Number = 1
+	headerSeq           protowire.Number = 2
+	headerError         protowire.Number = 3
+	headerKind          protowire.Number = 4
+	headerTimeout       protowire.Number = 5
+	headerMetadata      protowire.Number = 6 // map<string, string>
+	headerCode          protowire.Number = 7
+	headerDetails       protowire.Number = 8 // repeated Detail{string type = 1; bytes value = 2}
+	headerWindow        protowire.Number = 9
+	headerBatch         protowire.Number = 10
+)
+
+// ProtobufCodec 使用 protobuf 编码 Header 和 body
+// protobuf 的消息不是自定界的, 每条消息前面都带有 uvarint 编码的长度
+// body 必须实现 proto.Message
+type ProtobufCodec struct {
+	conn io.ReadWriteCloser
+	buf  *bufio.Writer
+	r    *bufio.Reader
+}
+
+func NewProtobufCodec(conn io.ReadWriteCloser) Codec {
+	return &ProtobufCodec{
+		conn: conn,
+		buf:  bufio.NewWriter(conn),
+		r:    bufio.NewReader(conn),
+	}
+}
+
+func (c *ProtobufCodec) Close() error {
+	return c.conn.Close()
+}
+
+func (c *ProtobufCodec) ReadHeader(h *Header) error {
+	data, err := c.readMessage()
+	if err != nil {
+		return err
+	}
+	return unmarshalHeader(data, h)
+}
+
+// ReadBody body 为 nil 时丢弃这条消息
+func (c *ProtobufCodec) ReadBody(body interface{}) error {
+	data, err := c.readMessage()
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		return nil
+	}
+	m, ok := protoMessage(body)
+	if !ok {
+		return fmt.Errorf("codec protobuf: %T does not implement proto.Message", body)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func (c *ProtobufCodec) Write(h *Header, body interface{}) error {
+	// 先把 header 和 body 都编码好再写入, 编码失败时链接上不会留下半条消息
+	var data []byte
+	switch body.(type) {
+	case nil, struct{}:
+		// 错误响应的 body, 写一条空消息
+	default:
+		m, ok := protoMessage(body)
+		if !ok {
+			return fmt.Errorf("codec protobuf: %T does not implement proto.Message", body)
+		}
+		var err error
+		if data, err = proto.Marshal(m); err != nil {
+			return err
+		}
+	}
+
+	if err := c.writeMessage(marshalHeader(h)); err != nil {
+		_ = c.Close()
+		return err
+	}
+	if err := c.writeMessage(data); err != nil {
+		_ = c.Close()
+		return err
+	}
+	if err := c.buf.Flush(); err != nil {
+		_ = c.Close()
+		return err
+	}
+	return nil
+}
+
+func (c *ProtobufCodec) writeMessage(data []byte) error {
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(data)))
+	if _, err := c.buf.Write(size[:n]); err != nil {
+		return err
+	}
+	_, err := c.buf.Write(data)
+	return err
+}
+
+func (c *ProtobufCodec) readMessage() ([]byte, error) {
+	size, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxMessageSize {
+		return nil, fmt.Errorf("codec protobuf: message too large: %d bytes", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func marshalHeader(h *Header) []byte {
+	var b []byte
+	if h.ServiceMethod != "" {
+		b = protowire.AppendTag(b, headerServiceMethod, protowire.BytesType)
+		b = protowire.AppendString(b, h.ServiceMethod)
+	}
+	if h.Seq != 0 {
+		b = protowire.AppendTag(b, headerSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, h.Seq)
+	}
+	if h.Error != "" {
+		b = protowire.AppendTag(b, headerError, protowire.BytesType)
+		b = protowire.AppendString(b, h.Error)
+	}
+	if h.Kind != KindCall {
+		b = protowire.AppendTag(b, headerKind, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.Kind))
+	}
+	if h.Timeout != 0 {
+		b = protowire.AppendTag(b, headerTimeout, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.Timeout))
+	}
+	for k, v := range h.Metadata {
+		// map 的每一项按 protobuf 的规则编码为 key = 1, value = 2 的嵌套消息
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, k)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, v)
+		b = protowire.AppendTag(b, headerMetadata, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	if h.Code != 0 {
+		b = protowire.AppendTag(b, headerCode, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.Code))
+	}
+	for _, d := range h.Details {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, d.Type)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, d.Value)
+		b = protowire.AppendTag(b, headerDetails, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	if h.Window != 0 {
+		b = protowire.AppendTag(b, headerWindow, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.Window))
+	}
+	if h.Batch != 0 {
+		b = protowire.AppendTag(b, headerBatch, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.Batch))
+	}
+	return b
+}
+
+var errBadHeader = errors.New("codec protobuf: malformed header")
+
+func unmarshalHeader(b []byte, h *Header) error {
+	*h = Header{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errBadHeader
+		}
+		b = b[n:]
+		switch {
+		case num == headerServiceMethod && typ == protowire.BytesType:
+			h.ServiceMethod, n = protowire.ConsumeString(b)
+		case num == headerSeq && typ == protowire.VarintType:
+			h.Seq, n = protowire.ConsumeVarint(b)
+		case num == headerError && typ == protowire.BytesType:
+			h.Error, n = protowire.ConsumeString(b)
+		case num == headerKind && typ == protowire.VarintType:
+			var kind uint64
+			kind, n = protowire.ConsumeVarint(b)
+			h.Kind = Kind(kind)
+		case num == headerTimeout && typ == protowire.VarintType:
+			var timeout uint64
+			timeout, n = protowire.ConsumeVarint(b)
+			h.Timeout = time.Duration(timeout)
+		case num == headerMetadata && typ == protowire.BytesType:
+			var entry []byte
+			entry, n = protowire.ConsumeBytes(b)
+			if n >= 0 {
+				if err := unmarshalMetadataEntry(entry, h); err != nil {
+					return err
+				}
+			}
+		case num == headerCode && typ == protowire.VarintType:
+			var code uint64
+			code, n = protowire.ConsumeVarint(b)
+			h.Code = uint32(code)
+		case num == headerDetails && typ == protowire.BytesType:
+			var entry []byte
+			entry, n = protowire.ConsumeBytes(b)
+			if n >= 0 {
+				if err := unmarshalDetail(entry, h); err != nil {
+					return err
+				}
+			}
+		case num == headerWindow && typ == protowire.VarintType:
+			var window uint64
+			window, n = protowire.ConsumeVarint(b)
+			h.Window = uint32(window)
+		case num == headerBatch && typ == protowire.VarintType:
+			var batch uint64
+			batch, n = protowire.ConsumeVarint(b)
+			h.Batch = uint32(batch)
+		default:
+			// 跳过不认识的字段, 兼容以后新增的字段
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return errBadHeader
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func unmarshalMetadataEntry(b []byte, h *Header) error {
+	var k, v string
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errBadHeader
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			k, n = protowire.ConsumeString(b)
+		case num == 2 && typ == protowire.BytesType:
+			v, n = protowire.ConsumeString(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return errBadHeader
+		}
+		b = b[n:]
+	}
+	if h.Metadata == nil {
+		h.Metadata = make(map[string]string)
+	}
+	h.Metadata[k] = v
+	return nil
+}
+
+func unmarshalDetail(b []byte, h *Header) error {
+	var d Detail
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errBadHeader
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			d.Type, n = protowire.ConsumeString(b)
+		case num == 2 && typ == protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(b)
+			d.Value = append([]byte(nil), v...)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return errBadHeader
+		}
+		b = b[n:]
+	}
+	h.Details = append(h.Details, d)
+	return nil
+}
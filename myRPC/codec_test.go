@@ -0,0 +1,189 @@
+package myRPC
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "rpc/grpc/1.introduction/proto"
+	"rpc/myRPC/codec"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/status"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Echo int
+
+type Record struct {
+	ID   int64
+	Name string
+	Tags []string
+}
+
+func (e *Echo) Record(args Record, reply *Record) error {
+	*reply = args
+	return nil
+}
+
+func (e *Echo) ID(args int64, reply *interface{}) error {
+	*reply = args
+	return nil
+}
+
+func (e *Echo) Fail(args int, reply *int) error {
+	return errors.New("echo: fail")
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, new(Echo))
+	for _, opt := range codecOptions() {
+		opt := opt
+		t.Run(fmt.Sprintf("%s binary=%v", opt.CodeType, opt.BinaryHandshake), func(t *testing.T) {
+			client, err := Dial("tcp", addr, &opt)
+			_assert(err == nil, "dial: %v", err)
+			defer func() { _ = client.Close() }()
+
+			args := Record{ID: 1<<62 + 1, Name: "myRPC", Tags: []string{"a", "b"}}
+			var reply Record
+			err = client.Call(context.Background(), "Echo.Record", args, &reply)
+			_assert(err == nil, "call Echo.Record: %v", err)
+			_assert(reply.ID == args.ID && reply.Name == args.Name && len(reply.Tags) == 2, "wrong reply %+v", reply)
+
+			// an error reply must not break the connection for later calls
+			var n int
+			err = client.Call(context.Background(), "Echo.Fail", 1, &n)
+			_assert(err != nil && err.Error() == "echo: fail", "expect echo: fail, got %v", err)
+			err = client.Call(context.Background(), "Echo.Record", args, &reply)
+			_assert(err == nil, "call after error reply: %v", err)
+			_assert(client.IsAvailable(), "client should still be available")
+		})
+	}
+}
+
+// codecOptions returns options for every codec that can encode plain Go values,
+// in both the binary framing and the legacy handshake.
+func codecOptions() []Option {
+	var opts []Option
+	for _, binary := range []bool{true, false} {
+		for _, typ := range []codec.Type{codec.JsonType, codec.GobType, codec.MsgpackType} {
+			opt := DefaultOption
+			opt.CodeType = typ
+			opt.BinaryHandshake = binary
+			opts = append(opts, opt)
+		}
+	}
+	return opts
+}
+
+func TestGobCodec_KeepsInt64(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, new(Echo))
+	opt := DefaultOption
+	opt.CodeType = codec.GobType
+	client, err := Dial("tcp", addr, &opt)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var id interface{}
+	err = client.Call(context.Background(), "Echo.ID", int64(1<<53+1), &id)
+	_assert(err == nil, "call Echo.ID: %v", err)
+	_assert(id == int64(1<<53+1), "expect int64 %d, got %T %v", int64(1<<53+1), id, id)
+}
+
+type Greeter int
+
+func (g *Greeter) SayHello(args *pb.HelloRequest, reply *pb.HelloReply) error {
+	reply.Message = "Hello " + args.Name
+	return nil
+}
+
+// Name replies a string, which is not a protobuf message.
+func (g *Greeter) Name(args *pb.HelloRequest, reply *string) error {
+	*reply = args.Name
+	return nil
+}
+
+func TestProtobufCodec_RoundTrip(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, new(Greeter))
+
+	for _, binary := range []bool{true, false} {
+		opt := DefaultOption
+		opt.CodeType = codec.ProtobufType
+		opt.BinaryHandshake = binary
+		client, err := Dial("tcp", addr, &opt)
+		_assert(err == nil, "dial: %v", err)
+		testProtobufCalls(client)
+		_ = client.Close()
+	}
+}
+
+func testProtobufCalls(client *Client) {
+	var reply pb.HelloReply
+	err := client.Call(context.Background(), "Greeter.SayHello", &pb.HelloRequest{Name: "myRPC"}, &reply)
+	_assert(err == nil && reply.Message == "Hello myRPC", "call Greeter.SayHello: %v %q", err, reply.Message)
+
+	// error replies carry an empty body
+	err = client.Call(context.Background(), "Greeter.Nope", &pb.HelloRequest{}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect can't find method, got %v", err)
+
+	// arguments must be protobuf messages
+	err = client.Call(context.Background(), "Greeter.SayHello", "myRPC", &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "proto.Message"), "expect proto.Message error, got %v", err)
+	err = client.Call(context.Background(), "Greeter.SayHello", &pb.HelloRequest{Name: "again"}, &reply)
+	_assert(err == nil && reply.Message == "Hello again", "call after encode error: %v %q", err, reply.Message)
+
+	// a reply that can not be encoded is answered with an error
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var name string
+	err = client.Call(ctx, "Greeter.Name", &pb.HelloRequest{Name: "myRPC"}, &name)
+	_assert(status.Code(err) == codes.Internal && strings.Contains(err.Error(), "proto.Message"),
+		"expect Internal for a reply that is not a protobuf message, got %v", err)
+	err = client.Call(context.Background(), "Greeter.SayHello", &pb.HelloRequest{Name: "again"}, &reply)
+	_assert(err == nil && reply.Message == "Hello again", "call after a reply encode error: %v %q", err, reply.Message)
+}
+
+func TestCompression_RoundTrip(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, new(Echo))
+	big := Record{ID: 7, Name: strings.Repeat("record ", 1000)}
+	for _, opt := range codecOptions() {
+		typ := opt.CodeType
+		for _, ct := range []codec.CompressType{codec.CompressGzip, codec.CompressSnappy, codec.CompressZstd} {
+			opt.Compression = ct
+			client, err := Dial("tcp", addr, &opt)
+			_assert(err == nil, "dial %s+%s: %v", typ, ct, err)
+
+			for _, args := range []Record{{ID: 1, Name: "tiny"}, big} {
+				var reply Record
+				err = client.Call(context.Background(), "Echo.Record", args, &reply)
+				_assert(err == nil && reply.Name == args.Name, "%s+%s: call Echo.Record: %v", typ, ct, err)
+			}
+			var n int
+			err = client.Call(context.Background(), "Echo.Fail", 1, &n)
+			_assert(err != nil && err.Error() == "echo: fail", "%s+%s: expect echo: fail, got %v", typ, ct, err)
+			_ = client.Close()
+		}
+	}
+
+	opt := DefaultOption
+	opt.Compression = "lz4"
+	_, err := Dial("tcp", addr, &opt)
+	_assert(err != nil, "expect unknown compress type error")
+}
+
+func TestFrameCodec_MaxFrameSize(t *testing.T) {
+	addr := startTestServer(t, NewServer(MaxFrameSize(1024)), (*Server).Accept, new(Echo))
+
+	opt := DefaultOption
+	opt.BinaryHandshake = true
+	client, err := Dial("tcp", addr, &opt)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply Record
+	err = client.Call(context.Background(), "Echo.Record", Record{Name: strings.Repeat("x", 2048)}, &reply)
+	_assert(err != nil && err.Error() == codec.ErrFrameTooLarge.Error(), "expect frame too large, got %v", err)
+	// the oversized body was skipped, the connection keeps working
+	err = client.Call(context.Background(), "Echo.Record", Record{Name: "small"}, &reply)
+	_assert(err == nil && reply.Name == "small", "call after oversized frame: %v", err)
+}
@@ -0,0 +1,74 @@
+// Package codes defines the status codes of myRPC, they follow the codes of gRPC.
+package codes
+
+import "strconv"
+
+// A Code is a status code carried by an RPC error.
+type Code uint32
+
+const (
+	// OK is returned on success.
+	OK Code = iota
+	// Canceled indicates the call was cancelled, typically by the caller.
+	Canceled
+	// Unknown error, such as an error returned by a method that is not a status.
+	Unknown
+	// InvalidArgument indicates the arguments of the call can't be decoded or are wrong.
+	InvalidArgument
+	// DeadlineExceeded means the call didn't complete before its deadline
+	// or the server's handle timeout.
+	DeadlineExceeded
+	// NotFound means some requested entity was not found.
+	NotFound
+	// AlreadyExists means an entity the call attempted to create already exists.
+	AlreadyExists
+	// PermissionDenied indicates the caller is not allowed to make the call.
+	PermissionDenied
+	// ResourceExhausted indicates some resource has been exhausted, such as
+	// a rate limit or the maximum frame size.
+	ResourceExhausted
+	// FailedPrecondition indicates the system is not in a state required for the call.
+	FailedPrecondition
+	// Aborted indicates the call was aborted.
+	Aborted
+	// OutOfRange means the call was attempted past the valid range.
+	OutOfRange
+	// Unimplemented indicates the service or method is not registered on the server.
+	Unimplemented
+	// Internal errors, such as a panic in a method.
+	Internal
+	// Unavailable indicates the server is shutting down or the connection is lost,
+	// the call may be retried on another connection.
+	Unavailable
+	// DataLoss indicates unrecoverable data loss or corruption.
+	DataLoss
+	// Unauthenticated indicates the caller does not have valid credentials.
+	Unauthenticated
+)
+
+var codeNames = [...]string{
+	OK:                 "OK",
+	Canceled:           "Canceled",
+	Unknown:            "Unknown",
+	InvalidArgument:    "InvalidArgument",
+	DeadlineExceeded:   "DeadlineExceeded",
+	NotFound:           "NotFound",
+	AlreadyExists:      "AlreadyExists",
+	PermissionDenied:   "PermissionDenied",
+	ResourceExhausted:  "ResourceExhausted",
+	FailedPrecondition: "FailedPrecondition",
+	Aborted:            "Aborted",
+	OutOfRange:         "OutOfRange",
+	Unimplemented:      "Unimplemented",
+	Internal:           "Internal",
+	Unavailable:        "Unavailable",
+	DataLoss:           "DataLoss",
+	Unauthenticated:    "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return "Code(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
@@ -0,0 +1,147 @@
+package myRPC
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Waiter's methods block until their context is done.
+type Waiter struct {
+	stopped chan error
+}
+
+func (w *Waiter) Wait(ctx context.Context, args int, reply *int) error {
+	select {
+	case <-ctx.Done():
+		w.stopped <- ctx.Err()
+		return ctx.Err()
+	case <-time.After(5 * time.Second):
+		return nil
+	}
+}
+
+func (w *Waiter) Double(ctx context.Context, args int, reply *int) error {
+	*reply = args * 2
+	return nil
+}
+
+func newWaiter() *Waiter {
+	return &Waiter{stopped: make(chan error, 1)}
+}
+
+func TestNewService_ContextMethods(t *testing.T) {
+	s := newService(&Waiter{})
+	_assert(len(s.method) == 2, "wrong service Method, expect 2, but got %d", len(s.method))
+	_assert(s.method["Wait"].hasContext && s.method["Double"].hasContext, "methods should take a context")
+}
+
+func TestContextMethod_Call(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, newWaiter())
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call(context.Background(), "Waiter.Double", 21, &reply)
+	_assert(err == nil && reply == 42, "call Waiter.Double: %v %d", err, reply)
+}
+
+func TestContextMethod_HandleTimeout(t *testing.T) {
+	w := newWaiter()
+	addr := startTestServer(t, NewServer(), (*Server).Accept, w)
+	opt := DefaultOption
+	opt.HandleTimeout = 100 * time.Millisecond
+	client, err := Dial("tcp", addr, &opt)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call(context.Background(), "Waiter.Wait", 1, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error, got %v", err)
+	select {
+	case err := <-w.stopped:
+		_assert(err == context.DeadlineExceeded, "expect DeadlineExceeded, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("handler context was not cancelled on handle timeout")
+	}
+}
+
+func TestContextMethod_ConnectionLost(t *testing.T) {
+	w := newWaiter()
+	addr := startTestServer(t, NewServer(), (*Server).Accept, w)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+
+	_ = client.Go("Waiter.Wait", 1, new(int), nil)
+	time.Sleep(50 * time.Millisecond)
+	_ = client.Close()
+	select {
+	case err := <-w.stopped:
+		_assert(err == context.Canceled, "expect Canceled, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("handler context was not cancelled when the connection was lost")
+	}
+}
+
+// Relay forwards calls to another server with the context of the handler.
+type Relay struct {
+	client *Client
+}
+
+func (r *Relay) Wait(ctx context.Context, args int, reply *int) error {
+	return r.client.Call(ctx, "Waiter.Wait", args, reply)
+}
+
+func TestDeadline_Propagation(t *testing.T) {
+	w := newWaiter()
+	waiterAddr := startTestServer(t, NewServer(), (*Server).Accept, w)
+	downstream, err := Dial("tcp", waiterAddr)
+	_assert(err == nil, "dial waiter: %v", err)
+	defer func() { _ = downstream.Close() }()
+
+	addr := startTestServer(t, NewServer(), (*Server).Accept, &Relay{client: downstream})
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial relay: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// the deadline travels client -> Relay -> Waiter
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = client.Call(ctx, "Relay.Wait", 1, new(int))
+	_assert(err != nil && strings.Contains(err.Error(), context.DeadlineExceeded.Error()), "expect deadline exceeded, got %v", err)
+	select {
+	case err := <-w.stopped:
+		_assert(err == context.DeadlineExceeded, "expect DeadlineExceeded, got %v", err)
+		_assert(time.Since(start) < time.Second, "waiter stopped too late: %v", time.Since(start))
+	case <-time.After(time.Second):
+		t.Fatal("the deadline did not reach the last hop")
+	}
+
+	// an expired context is not sent at all
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	err = client.Call(expired, "Relay.Wait", 1, new(int))
+	_assert(err != nil && strings.Contains(err.Error(), context.DeadlineExceeded.Error()), "expect deadline exceeded, got %v", err)
+}
+
+func TestDeadline_ExpiredOnArrival(t *testing.T) {
+	w := newWaiter()
+	addr := startTestServer(t, NewServer(), (*Server).Accept, w)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// a request whose deadline passed on the way is dropped without running the method
+	call := &Call{ServiceMethod: "Waiter.Wait", Args: 1, Reply: new(int), Done: make(chan *Call, 1), timeout: -time.Millisecond}
+	client.send(call)
+	select {
+	case <-w.stopped:
+		t.Fatal("the expired request should not run")
+	case <-call.Done:
+		t.Fatalf("the expired request should not be answered, got %v", call.Error)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -10,16 +10,47 @@ import (
 const debugText = `<html>
 	<body>
 	<title>Services</title>
-	{{range .}}
+	{{range .Services}}
 	<hr>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Panics</th><th align=center>Limited</th>
 		{{range .Method}}
 			<tr>
-			<td align=left font=fixed>{{.Name}}({{.Type.ArgType}}, {{.Type.ReplyType}}) error</td>
+			<td align=left font=fixed>{{.Name}}({{with .Type.ArgType}}{{.}}, {{end}}{{.Type.ReplyType}}) error</td>
 			<td align=center>{{.Type.NumCalls}}</td>
+			<td align=center>{{.Type.NumPanics}}</td>
+			<td align=center>{{.Type.NumLimited}}</td>
+			</tr>
+		{{end}}
+		</table>
+	{{end}}
+	{{with .Pool}}
+	<hr>
+	Worker pool
+	<hr>
+		<table>
+		<th align=center>Workers</th><th align=center>Queue depth</th><th align=center>Shed policy</th><th align=center>Queued</th><th align=center>Shed</th>
+			<tr>
+			<td align=center>{{.Workers}}</td>
+			<td align=center>{{.Depth}}</td>
+			<td align=center>{{.Policy}}</td>
+			<td align=center>{{.Queued}}</td>
+			<td align=center>{{.Shed}}</td>
+			</tr>
+		</table>
+	{{end}}
+	{{with .Clients}}
+	<hr>
+	Limited clients
+	<hr>
+		<table>
+		<th align=center>Client</th><th align=center>Limited</th>
+		{{range .}}
+			<tr>
+			<td align=left font=fixed>{{.Client}}</td>
+			<td align=center>{{.Hits}}</td>
 			</tr>
 		{{end}}
 		</table>
@@ -47,6 +78,13 @@ type debugService struct {
 
 type serviceArray []debugService
 
+// debugPage is the data of the debug page.
+type debugPage struct {
+	Services serviceArray
+	Clients  []clientHits // the clients with calls rejected by ClientLimit
+	Pool     *poolStats   // nil without a WorkerPool
+}
+
 func (s serviceArray) Len() int           { return len(s) }
 func (s serviceArray) Less(i, j int) bool { return s[i].Name < s[j].Name }
 func (s serviceArray) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -74,7 +112,14 @@ func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return true
 	})
 	sort.Sort(services)
-	err := debug.Execute(w, services)
+	page := debugPage{Services: services}
+	if server.clientLimits != nil {
+		page.Clients = server.clientLimits.hits()
+	}
+	if server.pool != nil {
+		page.Pool = server.pool.stats()
+	}
+	err := debug.Execute(w, page)
 	if err != nil {
 		fmt.Fprintln(w, "rpc: error executing template:", err.Error())
 	}
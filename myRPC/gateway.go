@@ -0,0 +1,200 @@
+package myRPC
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/textproto"
+	"rpc/myRPC/codec"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/metadata"
+	"rpc/myRPC/status"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultGatewayPath is the path prefix HandleGateway serves the methods under.
+	DefaultGatewayPath = "/rpc/"
+	// MetadataHeaderPrefix prefixes the HTTP headers the gateway maps to
+	// metadata: request headers to the incoming metadata of the method,
+	// the trailer of the method to response headers.
+	MetadataHeaderPrefix = "Rpc-Metadata-"
+	// TimeoutHeader is the HTTP header carrying the timeout of the call
+	// in the format of time.ParseDuration, such as "500ms".
+	TimeoutHeader = "Rpc-Timeout"
+)
+
+// GatewayHandler returns an http.Handler serving every registered method as
+// a plain HTTP endpoint, POST prefix + "Service.Method" with the argument as
+// the JSON body. The reply is the JSON body of a 200 response. An error is
+// answered with the HTTP status matching its code and a JSON body holding
+// its code, message and details.
+//
+// Headers with MetadataHeaderPrefix and the Authorization header become the
+// incoming metadata, the trailer is sent in headers with MetadataHeaderPrefix.
+// Serve the handler over HTTPS when clients send an Authorization header.
+func (server *Server) GatewayHandler(prefix string) http.Handler {
+	return &gateway{server: server, prefix: prefix}
+}
+
+// HandleGateway registers the GatewayHandler of the server on prefix.
+// It is still necessary to invoke http.Serve(), typically in a go statement.
+func (server *Server) HandleGateway(prefix string) {
+	http.Handle(prefix, server.GatewayHandler(prefix))
+}
+
+// HandleGateway registers the GatewayHandler of DefaultServer on DefaultGatewayPath.
+// It is still necessary to invoke http.Serve(), typically in a go statement.
+func HandleGateway() {
+	DefaultServer.HandleGateway(DefaultGatewayPath)
+}
+
+type gateway struct {
+	server *Server
+	prefix string
+}
+
+// gatewayError is the body of an error response.
+type gatewayError struct {
+	Code    codes.Code   `json:"code"`
+	Message string       `json:"message"`
+	Details []jsonDetail `json:"details,omitempty"`
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 must POST\n")
+		return
+	}
+	req := &request{h: &codec.Header{ServiceMethod: strings.TrimPrefix(r.URL.Path, g.prefix)}, md: metadataFromHeader(r.Header)}
+	sc, ok := g.server.beginHTTPRequest(r)
+	if !ok {
+		status.Convert(errServerShutdown).ToHeader(req.h)
+		g.writeResponse(w, req.h, nil)
+		return
+	}
+	defer g.server.endHTTPRequest(sc)
+
+	if err := g.readRequest(w, r, req); err != nil {
+		status.Convert(err).ToHeader(req.h)
+		g.writeResponse(w, req.h, nil)
+		return
+	}
+	sc.prepareRequest(req, 0)
+	body, ok := g.server.callRequest(sc, req, 0)
+	if !ok {
+		if sc.ctx.Err() != nil {
+			// the client is gone
+			return
+		}
+		status.New(codes.DeadlineExceeded, "rpc: gateway: "+context.DeadlineExceeded.Error()).ToHeader(req.h)
+	}
+	g.writeResponse(w, req.h, body)
+}
+
+// readRequest finds the method of req and decodes the body of r into its argument.
+func (g *gateway) readRequest(w http.ResponseWriter, r *http.Request, req *request) error {
+	if t := r.Header.Get(TimeoutHeader); t != "" {
+		timeout, err := time.ParseDuration(t)
+		if err != nil || timeout <= 0 {
+			return status.Errorf(codes.InvalidArgument, "rpc: gateway: invalid %s %q", TimeoutHeader, t)
+		}
+		req.deadline = time.Now().Add(timeout)
+	}
+	argvi, err := g.server.newRequest(req)
+	if err != nil {
+		return err
+	}
+
+	maxSize := g.server.maxFrameSize
+	if maxSize == 0 {
+		maxSize = codec.DefaultMaxFrameSize
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxSize)))
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, "rpc: gateway: "+err.Error())
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		// no body, the zero argument
+		return nil
+	}
+	if err := json.Unmarshal(body, argvi); err != nil {
+		return status.Error(codes.InvalidArgument, "rpc: gateway: invalid body: "+err.Error())
+	}
+	return nil
+}
+
+// writeResponse writes the reply body, or the error in h, and the trailer in h.
+func (g *gateway) writeResponse(w http.ResponseWriter, h *codec.Header, body interface{}) {
+	for k, v := range h.Metadata {
+		w.Header().Set(MetadataHeaderPrefix+k, v)
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	code := http.StatusOK
+	if s := status.FromHeader(h); s != nil {
+		code = httpStatusFromCode(s.Code())
+		body = &gatewayError{Code: s.Code(), Message: s.Message(), Details: jsonDetails(h.Details)}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("rpc server: gateway: encode reply of %s: %v", h.ServiceMethod, err)
+		code = http.StatusInternalServerError
+		data, _ = json.Marshal(&gatewayError{Code: codes.Internal, Message: "rpc: gateway: can not encode reply"})
+	}
+	w.WriteHeader(code)
+	_, _ = w.Write(data)
+}
+
+// metadataFromHeader returns the metadata carried by the HTTP header.
+func metadataFromHeader(header http.Header) metadata.MD {
+	md := metadata.MD{}
+	prefix := textproto.CanonicalMIMEHeaderKey(MetadataHeaderPrefix)
+	for k, v := range header {
+		if strings.HasPrefix(k, prefix) && len(v) > 0 {
+			md.Set(k[len(prefix):], v[0])
+		}
+	}
+	if auth := header.Get("Authorization"); auth != "" {
+		md.Set("authorization", auth)
+	}
+	return md
+}
+
+// httpStatusFromCode returns the HTTP status the gateway answers an error with code c.
+func httpStatusFromCode(c codes.Code) int {
+	switch c {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // Client Closed Request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,121 @@
+package myRPC
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"rpc/myRPC/codes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_Gateway(t *testing.T) {
+	server := NewServer()
+	_assert(server.Register(new(Calc)) == nil, "register Calc")
+	_assert(server.Register(new(Strict)) == nil, "register Strict")
+	_assert(server.Register(new(Meta)) == nil, "register Meta")
+	_assert(server.Register(new(Tally)) == nil, "register Tally")
+	_assert(server.Register(new(Pager)) == nil, "register Pager")
+	ts := httptest.NewServer(server.GatewayHandler(DefaultGatewayPath))
+	defer ts.Close()
+
+	post := func(method, body string, header http.Header) (*http.Response, string) {
+		req, err := http.NewRequest("POST", ts.URL+DefaultGatewayPath+method, strings.NewReader(body))
+		_assert(err == nil, "new request: %v", err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		_assert(err == nil, "post %s: %v", method, err)
+		defer func() { _ = resp.Body.Close() }()
+		data, err := ioutil.ReadAll(resp.Body)
+		_assert(err == nil, "read body of %s: %v", method, err)
+		return resp, strings.TrimSpace(string(data))
+	}
+	checkError := func(method, body string, header http.Header, httpStatus int, code codes.Code) gatewayError {
+		resp, data := post(method, body, header)
+		_assert(resp.StatusCode == httpStatus, "%s: expect HTTP %d, got %s %s", method, httpStatus, resp.Status, data)
+		var e gatewayError
+		_assert(json.Unmarshal([]byte(data), &e) == nil, "%s: decode error %s", method, data)
+		_assert(e.Code == code, "%s: expect %s, got %s", method, code, e.Code)
+		return e
+	}
+
+	resp, data := post("Calc.Add", `{"Num1":1,"Num2":2}`, nil)
+	_assert(resp.StatusCode == http.StatusOK && data == "3", "expect 3, got %s %s", resp.Status, data)
+	_assert(resp.Header.Get("Content-Type") == "application/json", "got Content-Type %s", resp.Header.Get("Content-Type"))
+	resp, data = post("Calc.Add", "", nil)
+	_assert(resp.StatusCode == http.StatusOK && data == "0", "expect the zero argument without a body, got %s %s", resp.Status, data)
+
+	resp, data = post("Meta.Echo", `"hi"`, http.Header{"Rpc-Metadata-Request-Id": {"42"}})
+	_assert(resp.StatusCode == http.StatusOK && data == `"hi 42"`, "expect metadata from headers, got %s %s", resp.Status, data)
+	_assert(resp.Header.Get("Rpc-Metadata-Served-By") == "meta" && resp.Header.Get("Rpc-Metadata-Request-Id") == "42",
+		"expect trailer in headers, got %v", resp.Header)
+
+	e := checkError("Strict.Check", `""`, nil, http.StatusBadRequest, codes.InvalidArgument)
+	_assert(e.Message == "strict: empty name" && len(e.Details) == 1, "expect status details, got %+v", e)
+	checkError("Calc.Add", `{"Num1":"x"}`, nil, http.StatusBadRequest, codes.InvalidArgument)
+	checkError("Calc.Nope", `{}`, nil, http.StatusNotImplemented, codes.Unimplemented)
+	checkError("Pager.List", `{}`, nil, http.StatusNotImplemented, codes.Unimplemented)
+	checkError("Tally.Sleep", `500`, http.Header{TimeoutHeader: {"50ms"}}, http.StatusGatewayTimeout, codes.DeadlineExceeded)
+	checkError("Tally.Sleep", `0`, http.Header{TimeoutHeader: {"soon"}}, http.StatusBadRequest, codes.InvalidArgument)
+
+	get, err := http.Get(ts.URL + DefaultGatewayPath + "Calc.Add")
+	_assert(err == nil, "get: %v", err)
+	_ = get.Body.Close()
+	_assert(get.StatusCode == http.StatusMethodNotAllowed, "expect 405 for GET, got %s", get.Status)
+}
+
+func TestHTTPStatusFromCode(t *testing.T) {
+	for c, expect := range map[codes.Code]int{
+		codes.OK:                http.StatusOK,
+		codes.NotFound:          http.StatusNotFound,
+		codes.PermissionDenied:  http.StatusForbidden,
+		codes.Unauthenticated:   http.StatusUnauthorized,
+		codes.ResourceExhausted: http.StatusTooManyRequests,
+		codes.Unavailable:       http.StatusServiceUnavailable,
+		codes.Unknown:           http.StatusInternalServerError,
+	} {
+		_assert(httpStatusFromCode(c) == expect, "%s: expect %d, got %d", c, expect, httpStatusFromCode(c))
+	}
+}
+
+func TestServer_GatewayShutdown(t *testing.T) {
+	server := NewServer()
+	gate := newGate()
+	_assert(server.Register(gate) == nil, "register Gate")
+	ts := httptest.NewServer(server.GatewayHandler(DefaultGatewayPath))
+	defer ts.Close()
+	defer gate.open()
+	post := func() *http.Response {
+		resp, err := http.Post(ts.URL+DefaultGatewayPath+"Gate.Pass", "application/json", strings.NewReader("1"))
+		_assert(err == nil, "post: %v", err)
+		return resp
+	}
+
+	inFlight := make(chan *http.Response, 1)
+	go func() { inFlight <- post() }()
+	<-gate.started
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned with a request in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	resp := post()
+	_ = resp.Body.Close()
+	_assert(resp.StatusCode == http.StatusServiceUnavailable, "expect 503 during shutdown, got %s", resp.Status)
+
+	gate.open()
+	resp = <-inFlight
+	data, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	_assert(err == nil && resp.StatusCode == http.StatusOK && strings.TrimSpace(string(data)) == "1",
+		"the request in flight should be answered, got %s %s", resp.Status, data)
+	_assert(<-shutdown == nil, "Shutdown should drain without error")
+}
@@ -0,0 +1,269 @@
+package myRPC
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"rpc/myRPC/codec"
+	"rpc/myRPC/codes"
+	"sync"
+)
+
+// Compatibility with Go's net/rpc. Stock net/rpc and net/rpc/jsonrpc clients
+// send no Option handshake, and know nothing about metadata, status codes,
+// deadlines, streams or GoAway. The server serves them with the same read loop,
+// dropping what the clients can not express, and a Client calls stock servers
+// with plain calls only.
+
+// ServeGoRPC serves a stock net/rpc client, which speaks gob, on a single connection.
+// ServeGoRPC blocks, serving the connection until the client hangs up.
+func (server *Server) ServeGoRPC(conn net.Conn) {
+	server.serveGoCodec(conn, newGobRPCCodec(conn))
+}
+
+// ServeGoJSONRPC serves a stock net/rpc/jsonrpc client on a single connection.
+// ServeGoJSONRPC blocks, serving the connection until the client hangs up.
+func (server *Server) ServeGoJSONRPC(conn net.Conn) {
+	server.serveGoCodec(conn, jsonrpc.NewServerCodec(conn))
+}
+
+// AcceptGoRPC accepts connections on the listener and serves
+// stock net/rpc clients, see ServeGoRPC.
+func (server *Server) AcceptGoRPC(lis net.Listener) {
+	server.accept(lis, server.ServeGoRPC)
+}
+
+// AcceptGoJSONRPC accepts connections on the listener and serves
+// stock net/rpc/jsonrpc clients, see ServeGoJSONRPC.
+func (server *Server) AcceptGoJSONRPC(lis net.Listener) {
+	server.accept(lis, server.ServeGoJSONRPC)
+}
+
+// HandleGoHTTP registers an HTTP handler on rpcPath for stock net/rpc
+// clients connecting with rpc.DialHTTPPath.
+// It is still necessary to invoke http.Serve(), typically in a go statement.
+func (server *Server) HandleGoHTTP(rpcPath string) {
+	http.Handle(rpcPath, goRPCHTTP{server})
+}
+
+func (server *Server) serveGoCodec(conn net.Conn, c rpc.ServerCodec) {
+	sc := &serverConn{conn: conn, sending: new(sync.Mutex), wg: new(sync.WaitGroup)}
+	sc.ctx, sc.cancel = context.WithCancel(context.Background())
+	if !server.trackConn(sc, true) {
+		sc.cancel()
+		_ = conn.Close()
+		return
+	}
+	defer func() {
+		server.trackConn(sc, false)
+		sc.cancel()
+		_ = conn.Close()
+	}()
+	if err := handshakeTLS(conn, tlsHandshakeTimeout); err != nil {
+		log.Printf("server: tls handshake error:%v", err)
+		return
+	}
+	sc.ctx = withPeer(sc.ctx, conn)
+	server.serverCodec(sc, &goServerCodec{c: c}, &Option{})
+}
+
+// goRPCHTTP answers the HTTP CONNECT of a stock net/rpc client.
+type goRPCHTTP struct {
+	server *Server
+}
+
+func (h goRPCHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "CONNECT" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 must CONNECT\n")
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		log.Print("rpc hijacking ", req.RemoteAddr, ": ", err.Error())
+		return
+	}
+	io.WriteString(conn, "HTTP/1.0 "+connected+"\n\n")
+	h.server.ServeGoRPC(conn)
+}
+
+// NewGoRPCClient returns a client calling a stock net/rpc server on conn.
+func NewGoRPCClient(conn io.ReadWriteCloser) *Client {
+	return newClientCodec(&goClientCodec{c: newGobRPCCodec(conn)}, nil, false)
+}
+
+// NewGoJSONRPCClient returns a client calling a stock net/rpc/jsonrpc server on conn.
+func NewGoJSONRPCClient(conn io.ReadWriteCloser) *Client {
+	return newClientCodec(&goClientCodec{c: jsonrpc.NewClientCodec(conn)}, nil, false)
+}
+
+// DialGoRPC connects to a stock net/rpc server at the specified network address.
+func DialGoRPC(network, address string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewGoRPCClient(conn), nil
+}
+
+// DialGoJSONRPC connects to a stock net/rpc/jsonrpc server at the specified network address.
+func DialGoJSONRPC(network, address string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewGoJSONRPCClient(conn), nil
+}
+
+// DialGoHTTP connects to a stock net/rpc server at the specified network
+// address listening on the default HTTP RPC path.
+func DialGoHTTP(network, address string) (*Client, error) {
+	return DialGoHTTPPath(network, address, rpc.DefaultRPCPath)
+}
+
+// DialGoHTTPPath connects to a stock net/rpc server at the specified network address and path.
+func DialGoHTTPPath(network, address, path string) (*Client, error) {
+	return dialHTTPPath(network, address, path, nil, func(conn net.Conn) (*Client, error) {
+		return NewGoRPCClient(conn), nil
+	})
+}
+
+// goServerCodec serves a net/rpc client through the codec of net/rpc.
+type goServerCodec struct {
+	c rpc.ServerCodec
+}
+
+func (c *goServerCodec) ReadHeader(h *codec.Header) error {
+	var req rpc.Request
+	if err := c.c.ReadRequestHeader(&req); err != nil {
+		return err
+	}
+	*h = codec.Header{ServiceMethod: req.ServiceMethod, Seq: req.Seq}
+	return nil
+}
+
+func (c *goServerCodec) ReadBody(body interface{}) error {
+	return c.c.ReadRequestBody(body)
+}
+
+func (c *goServerCodec) Write(h *codec.Header, body interface{}) error {
+	if h.Kind != codec.KindCall {
+		// a GoAway, net/rpc has no way to tell the client
+		return nil
+	}
+	resp := &rpc.Response{ServiceMethod: h.ServiceMethod, Seq: h.Seq, Error: h.Error}
+	if resp.Error == "" && h.Code != 0 {
+		resp.Error = "rpc: error code " + codes.Code(h.Code).String()
+	}
+	return c.c.WriteResponse(resp, body)
+}
+
+func (c *goServerCodec) Close() error {
+	return c.c.Close()
+}
+
+var errGoRPCUnsupported = errors.New("rpc: not supported by net/rpc servers")
+
+// goClientCodec calls a net/rpc server through the codec of net/rpc.
+type goClientCodec struct {
+	c rpc.ClientCodec
+}
+
+func (c *goClientCodec) ReadHeader(h *codec.Header) error {
+	var resp rpc.Response
+	if err := c.c.ReadResponseHeader(&resp); err != nil {
+		return err
+	}
+	*h = codec.Header{ServiceMethod: resp.ServiceMethod, Seq: resp.Seq, Error: resp.Error}
+	return nil
+}
+
+func (c *goClientCodec) ReadBody(body interface{}) error {
+	return c.c.ReadResponseBody(body)
+}
+
+func (c *goClientCodec) Write(h *codec.Header, body interface{}) error {
+	switch h.Kind {
+	case codec.KindCall, codec.KindBatch, codec.KindBatchInOrder:
+		// the calls of a batch are sent one by one
+	case codec.KindCancel:
+		// the server finishes the call, the reply is dropped
+		return nil
+	default:
+		return errGoRPCUnsupported
+	}
+	return c.c.WriteRequest(&rpc.Request{ServiceMethod: h.ServiceMethod, Seq: h.Seq}, body)
+}
+
+func (c *goClientCodec) Close() error {
+	return c.c.Close()
+}
+
+// gobRPCCodec is the gob wire format of net/rpc, which does not export it.
+// It implements both rpc.ServerCodec and rpc.ClientCodec.
+type gobRPCCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+}
+
+func newGobRPCCodec(conn io.ReadWriteCloser) *gobRPCCodec {
+	buf := bufio.NewWriter(conn)
+	return &gobRPCCodec{rwc: conn, dec: gob.NewDecoder(conn), enc: gob.NewEncoder(buf), encBuf: buf}
+}
+
+func (c *gobRPCCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobRPCCodec) ReadRequestBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobRPCCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	return c.write(r, body)
+}
+
+func (c *gobRPCCodec) ReadResponseHeader(r *rpc.Response) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobRPCCodec) ReadResponseBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobRPCCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	return c.write(r, body)
+}
+
+func (c *gobRPCCodec) write(header, body interface{}) (err error) {
+	if err = c.enc.Encode(header); err != nil {
+		// a half written message leaves the peer unable to decode, give up the connection
+		if c.encBuf.Flush() == nil {
+			log.Println("rpc: gob error encoding header:", err)
+			_ = c.Close()
+		}
+		return err
+	}
+	if err = c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Println("rpc: gob error encoding body:", err)
+			_ = c.Close()
+		}
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *gobRPCCodec) Close() error {
+	return c.rwc.Close()
+}
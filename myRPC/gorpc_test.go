@@ -0,0 +1,122 @@
+package myRPC
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/status"
+	"strings"
+	"testing"
+)
+
+// checkStockClient calls a myRPC server with a stock net/rpc client.
+func checkStockClient(name string, client *rpc.Client) {
+	defer func() { _ = client.Close() }()
+	var sum int
+	err := client.Call("Calc.Add", Args{Num1: 1, Num2: 2}, &sum)
+	_assert(err == nil && sum == 3, "%s: expect 3, got %d %v", name, sum, err)
+
+	// concurrent calls
+	calls := make([]*rpc.Call, 10)
+	for i := range calls {
+		calls[i] = client.Go("Calc.Add", Args{Num1: i, Num2: i}, new(int), nil)
+	}
+	for i, call := range calls {
+		<-call.Done
+		_assert(call.Error == nil && *call.Reply.(*int) == 2*i, "%s: expect %d, got %v", name, 2*i, call.Error)
+	}
+
+	var reply string
+	err = client.Call("Strict.Check", "", &reply)
+	_assert(err != nil && err.Error() == "strict: empty name", "%s: expect the error of the method, got %v", name, err)
+	err = client.Call("Calc.Nope", Args{}, &sum)
+	_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "%s: expect unknown method, got %v", name, err)
+	err = client.Call("Calc.Add", Args{Num1: 2, Num2: 2}, &sum)
+	_assert(err == nil && sum == 4, "%s: expect the connection to work after errors, got %v", name, err)
+}
+
+func TestServer_GoRPCClients(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).AcceptGoRPC, new(Calc), new(Strict))
+	client, err := rpc.Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	checkStockClient("net/rpc", client)
+
+	addr = startTestServer(t, NewServer(), (*Server).AcceptGoJSONRPC, new(Calc), new(Strict))
+	client, err = jsonrpc.Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	checkStockClient("net/rpc/jsonrpc", client)
+
+	server := NewServer()
+	_assert(server.Register(new(Calc)) == nil, "register Calc")
+	_assert(server.Register(new(Strict)) == nil, "register Strict")
+	ts := httptest.NewServer(goRPCHTTP{server})
+	defer ts.Close()
+	client, err = rpc.DialHTTPPath("tcp", ts.Listener.Addr().String(), "/")
+	_assert(err == nil, "dial http: %v", err)
+	checkStockClient("net/rpc http", client)
+}
+
+// checkStockServer calls a stock net/rpc server with a myRPC client.
+func checkStockServer(name string, client *Client) {
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+	var sum int
+	err := client.Call(ctx, "Calc.Add", Args{Num1: 1, Num2: 2}, &sum)
+	_assert(err == nil && sum == 3, "%s: expect 3, got %d %v", name, sum, err)
+
+	var reply string
+	err = client.Call(ctx, "Strict.Check", "", &reply)
+	_assert(status.Code(err) == codes.Unknown && err.Error() == "strict: empty name", "%s: expect the error of the method, got %v", name, err)
+
+	replies := make([]int, 3)
+	calls := make([]*Call, len(replies))
+	for i := range calls {
+		calls[i] = &Call{ServiceMethod: "Calc.Add", Args: Args{Num1: i, Num2: 1}, Reply: &replies[i]}
+	}
+	_assert(client.Batch(ctx, calls) == nil, "%s: batch", name)
+	for i, call := range calls {
+		_assert(call.Error == nil && replies[i] == i+1, "%s: expect %d, got %d %v", name, i+1, replies[i], call.Error)
+	}
+
+	_, err = client.NewStream(ctx, "Calc.Add")
+	_assert(err != nil, "%s: expect streams to be unsupported", name)
+}
+
+func TestClient_GoRPCServers(t *testing.T) {
+	stock := rpc.NewServer()
+	_assert(stock.Register(new(Calc)) == nil, "register Calc")
+	_assert(stock.Register(new(Strict)) == nil, "register Strict")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "listen: %v", err)
+	defer func() { _ = l.Close() }()
+	go stock.Accept(l)
+	client, err := DialGoRPC("tcp", l.Addr().String())
+	_assert(err == nil, "dial: %v", err)
+	checkStockServer("net/rpc", client)
+
+	jl, err := net.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "listen: %v", err)
+	defer func() { _ = jl.Close() }()
+	go func() {
+		for {
+			conn, err := jl.Accept()
+			if err != nil {
+				return
+			}
+			go stock.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+	client, err = DialGoJSONRPC("tcp", jl.Addr().String())
+	_assert(err == nil, "dial: %v", err)
+	checkStockServer("net/rpc/jsonrpc", client)
+
+	ts := httptest.NewServer(stock)
+	defer ts.Close()
+	client, err = DialGoHTTPPath("tcp", ts.Listener.Addr().String(), "/")
+	_assert(err == nil, "dial http: %v", err)
+	checkStockServer("net/rpc http", client)
+}
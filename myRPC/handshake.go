@@ -0,0 +1,86 @@
+package myRPC
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"rpc/myRPC/codec"
+	"time"
+)
+
+// Option fields carried in the binary handshake frame.
+// Tags must never be reused, unknown tags are ignored by the server.
+const (
+	handshakeCompression       uint8 = 1
+	handshakeCompressThreshold uint8 = 2
+	handshakeHandleTimeout     uint8 = 3
+	handshakeCredentials       uint8 = 4
+)
+
+// writeHandshake sends opt to the server as a handshake frame.
+func writeHandshake(w io.Writer, opt *Option) error {
+	id, ok := codec.CodecID(opt.CodeType)
+	if !ok {
+		return errors.New("unknown code type")
+	}
+	var header []byte
+	if opt.Compression != codec.CompressNone {
+		header = codec.AppendField(header, handshakeCompression, []byte(opt.Compression))
+	}
+	if opt.CompressThreshold != 0 {
+		header = codec.AppendUvarintField(header, handshakeCompressThreshold, uint64(int64(opt.CompressThreshold)))
+	}
+	if opt.HandleTimeout != 0 {
+		header = codec.AppendUvarintField(header, handshakeHandleTimeout, uint64(opt.HandleTimeout))
+	}
+	if opt.Credentials != "" {
+		header = codec.AppendField(header, handshakeCredentials, []byte(opt.Credentials))
+	}
+	fh := codec.FrameHeader{Version: codec.FrameVersion, Flags: codec.FlagHandshake, CodecID: id}
+	return codec.WriteFrame(w, fh, header, nil)
+}
+
+// readHandshake reads the handshake frame sent by writeHandshake into opt.
+func readHandshake(r io.Reader, opt *Option) error {
+	fh, err := codec.ReadFrameHeader(r)
+	if err != nil {
+		return err
+	}
+	if fh.Flags&codec.FlagHandshake == 0 {
+		return errors.New("expect a handshake frame")
+	}
+	if fh.BodyLen != 0 {
+		return errors.New("handshake frame must not have a body")
+	}
+	codeType, ok := codec.CodecType(fh.CodecID)
+	if !ok {
+		return fmt.Errorf("unknown codec id %d", fh.CodecID)
+	}
+	header, err := codec.ReadFrameBlock(r, fh.HeaderLen)
+	if err != nil {
+		return err
+	}
+
+	*opt = Option{MagicNumber: MagicNumber, CodeType: codeType}
+	return codec.RangeFields(header, func(tag uint8, v []byte) error {
+		switch tag {
+		case handshakeCompression:
+			opt.Compression = codec.CompressType(v)
+		case handshakeCompressThreshold:
+			n, err := codec.Uvarint(v)
+			if err != nil {
+				return err
+			}
+			opt.CompressThreshold = int(int64(n))
+		case handshakeHandleTimeout:
+			n, err := codec.Uvarint(v)
+			if err != nil {
+				return err
+			}
+			opt.HandleTimeout = time.Duration(n)
+		case handshakeCredentials:
+			opt.Credentials = string(v)
+		}
+		return nil
+	})
+}
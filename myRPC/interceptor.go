@@ -0,0 +1,92 @@
+package myRPC
+
+import (
+	"context"
+)
+
+// UnaryServerInfo consists of various information about a call on the server side.
+// All per-call state used by interceptors can be found here.
+type UnaryServerInfo struct {
+	Server        *Server
+	ServiceMethod string // format "Service.Method"
+}
+
+// UnaryHandler calls the registered method with args and reply.
+// args and reply have the types declared by the method.
+type UnaryHandler func(ctx context.Context, args, reply interface{}) error
+
+// UnaryServerInterceptor intercepts the execution of a call on the server.
+// info contains all the information of this call the interceptor can operate on,
+// and handler is the wrapper of the method. It is the responsibility of the
+// interceptor to invoke handler to complete the call.
+type UnaryServerInterceptor func(ctx context.Context, args, reply interface{}, info *UnaryServerInfo, handler UnaryHandler) error
+
+// UnaryInterceptor returns a ServerOption that installs interceptors on the server.
+// Interceptors given in several options are chained in the order of the options,
+// the first one is the outermost.
+func UnaryInterceptor(interceptors ...UnaryServerInterceptor) ServerOption {
+	return func(server *Server) {
+		server.interceptors = append(server.interceptors, interceptors...)
+	}
+}
+
+// ChainUnaryServer creates a single interceptor out of a chain of many interceptors.
+// Execution is done in left-to-right order, including passing of context.
+// For example ChainUnaryServer(one, two, three) will execute one before two before three.
+func ChainUnaryServer(interceptors ...UnaryServerInterceptor) UnaryServerInterceptor {
+	switch len(interceptors) {
+	case 0:
+		return func(ctx context.Context, args, reply interface{}, info *UnaryServerInfo, handler UnaryHandler) error {
+			return handler(ctx, args, reply)
+		}
+	case 1:
+		return interceptors[0]
+	}
+	return func(ctx context.Context, args, reply interface{}, info *UnaryServerInfo, handler UnaryHandler) error {
+		return interceptors[0](ctx, args, reply, info, chainServerHandler(interceptors[1:], info, handler))
+	}
+}
+
+// chainServerHandler returns the handler that runs interceptors and then handler.
+func chainServerHandler(interceptors []UnaryServerInterceptor, info *UnaryServerInfo, handler UnaryHandler) UnaryHandler {
+	if len(interceptors) == 0 {
+		return handler
+	}
+	return func(ctx context.Context, args, reply interface{}) error {
+		return interceptors[0](ctx, args, reply, info, chainServerHandler(interceptors[1:], info, handler))
+	}
+}
+
+// UnaryInvoker is called by UnaryClientInterceptor to complete the call.
+type UnaryInvoker func(ctx context.Context, serviceMethod string, args, reply interface{}) error
+
+// UnaryClientInterceptor intercepts the execution of a call on the client.
+// It is the responsibility of the interceptor to call invoker to complete the call.
+type UnaryClientInterceptor func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error
+
+// ChainUnaryClient creates a single interceptor out of a chain of many interceptors.
+// Execution is done in left-to-right order, including passing of context.
+// For example ChainUnaryClient(one, two, three) will execute one before two before three.
+func ChainUnaryClient(interceptors ...UnaryClientInterceptor) UnaryClientInterceptor {
+	switch len(interceptors) {
+	case 0:
+		return func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error {
+			return invoker(ctx, serviceMethod, args, reply)
+		}
+	case 1:
+		return interceptors[0]
+	}
+	return func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error {
+		return interceptors[0](ctx, serviceMethod, args, reply, chainClientInvoker(interceptors[1:], invoker))
+	}
+}
+
+// chainClientInvoker returns the invoker that runs interceptors and then invoker.
+func chainClientInvoker(interceptors []UnaryClientInterceptor, invoker UnaryInvoker) UnaryInvoker {
+	if len(interceptors) == 0 {
+		return invoker
+	}
+	return func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+		return interceptors[0](ctx, serviceMethod, args, reply, chainClientInvoker(interceptors[1:], invoker))
+	}
+}
@@ -0,0 +1,62 @@
+package myRPC
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestInterceptor_Chain(t *testing.T) {
+	var trace []string
+	record := func(name string) UnaryServerInterceptor {
+		return func(ctx context.Context, args, reply interface{}, info *UnaryServerInfo, handler UnaryHandler) error {
+			trace = append(trace, name+" "+info.ServiceMethod)
+			err := handler(ctx, args, reply)
+			trace = append(trace, name+" done")
+			return err
+		}
+	}
+	deny := func(ctx context.Context, args, reply interface{}, info *UnaryServerInfo, handler UnaryHandler) error {
+		if info.ServiceMethod == "Echo.Fail" {
+			return errors.New("denied")
+		}
+		return handler(ctx, args, reply)
+	}
+	server := NewServer(UnaryInterceptor(record("one"), record("two")), UnaryInterceptor(deny))
+	addr := startTestServer(t, server, (*Server).Accept, new(Echo))
+
+	var sent []string
+	opt := DefaultOption
+	opt.UnaryInterceptor = ChainUnaryClient(
+		func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error {
+			sent = append(sent, serviceMethod)
+			return invoker(ctx, serviceMethod, args, reply)
+		},
+		func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker UnaryInvoker) error {
+			// rewrite the argument before it is sent
+			if r, ok := args.(Record); ok {
+				r.Name = "client " + r.Name
+				args = r
+			}
+			return invoker(ctx, serviceMethod, args, reply)
+		},
+	)
+	client, err := Dial("tcp", addr, &opt)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply Record
+	err = client.Call(context.Background(), "Echo.Record", Record{Name: "call"}, &reply)
+	_assert(err == nil && reply.Name == "client call", "call Echo.Record: %v %q", err, reply.Name)
+	want := []string{"one Echo.Record", "two Echo.Record", "two done", "one done"}
+	_assert(reflect.DeepEqual(trace, want), "server trace %v, want %v", trace, want)
+
+	var n int
+	err = client.Call(context.Background(), "Echo.Fail", 1, &n)
+	_assert(err != nil && err.Error() == "denied", "expect denied, got %v", err)
+
+	call := <-client.Go("Echo.Record", Record{Name: "go"}, &reply, nil).Done
+	_assert(call.Error == nil && reply.Name == "client go", "go Echo.Record: %v %q", call.Error, reply.Name)
+	_assert(reflect.DeepEqual(sent, []string{"Echo.Record", "Echo.Fail", "Echo.Record"}), "client saw %v", sent)
+}
@@ -0,0 +1,391 @@
+package myRPC
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"rpc/myRPC/codec"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/metadata"
+	"rpc/myRPC/status"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultJSONRPCPath is the path HandleJSONRPC serves JSON-RPC 2.0 on.
+const DefaultJSONRPCPath = "/jsonrpc"
+
+// Error codes defined by JSON-RPC 2.0. A status error of a method is sent
+// with the code matching its status code, or jsonrpcServerError, and the
+// status code in the data of the error.
+const (
+	jsonrpcParseError     = -32700
+	jsonrpcInvalidRequest = -32600
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+	jsonrpcInternalError  = -32603
+	jsonrpcServerError    = -32000
+)
+
+type jsonrpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"` // nil for a notification
+}
+
+type jsonrpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type jsonrpcError struct {
+	Code    int               `json:"code"`
+	Message string            `json:"message"`
+	Data    *jsonrpcErrorData `json:"data,omitempty"`
+}
+
+// jsonrpcErrorData carries the status of a method error.
+type jsonrpcErrorData struct {
+	Code    codes.Code   `json:"code"`
+	Details []jsonDetail `json:"details,omitempty"`
+}
+
+// jsonDetail is a detail of a status sent in JSON.
+type jsonDetail struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+func jsonDetails(details []codec.Detail) []jsonDetail {
+	var out []jsonDetail
+	for _, d := range details {
+		// details added by status.WithDetails are JSON, keep them readable
+		var value interface{} = d.Value
+		if json.Valid(d.Value) {
+			value = json.RawMessage(d.Value)
+		}
+		out = append(out, jsonDetail{Type: d.Type, Value: value})
+	}
+	return out
+}
+
+func newJSONRPCError(code int, message string) *jsonrpcResponse {
+	return &jsonrpcResponse{Version: "2.0", Error: &jsonrpcError{Code: code, Message: message}}
+}
+
+// statusToJSONRPC returns the JSON-RPC error of s.
+func statusToJSONRPC(s *status.Status, details []codec.Detail) *jsonrpcError {
+	e := &jsonrpcError{Code: jsonrpcServerError, Message: s.Message(), Data: &jsonrpcErrorData{Code: s.Code(), Details: jsonDetails(details)}}
+	switch s.Code() {
+	case codes.Unimplemented:
+		e.Code = jsonrpcMethodNotFound
+	case codes.InvalidArgument:
+		e.Code = jsonrpcInvalidParams
+	case codes.Internal:
+		e.Code = jsonrpcInternalError
+	}
+	return e
+}
+
+// AcceptJSONRPC accepts connections on the listener and serves JSON-RPC 2.0
+// requests for each incoming connection, see ServeJSONRPC.
+func (server *Server) AcceptJSONRPC(lis net.Listener) {
+	server.accept(lis, server.ServeJSONRPC)
+}
+
+// ServeJSONRPC serves JSON-RPC 2.0 on a single connection, without the
+// Option handshake. The client sends a stream of JSON requests or batches,
+// the responses are written as they are ready, one per line.
+// ServeJSONRPC blocks until the client hangs up and all requests are answered.
+func (server *Server) ServeJSONRPC(conn net.Conn) {
+	sc := &serverConn{conn: conn, sending: new(sync.Mutex), wg: new(sync.WaitGroup)}
+	sc.ctx, sc.cancel = context.WithCancel(context.Background())
+	if !server.trackConn(sc, true) {
+		sc.cancel()
+		_ = conn.Close()
+		return
+	}
+	defer func() {
+		server.trackConn(sc, false)
+		sc.cancel()
+		_ = conn.Close()
+	}()
+	if err := handshakeTLS(conn, tlsHandshakeTimeout); err != nil {
+		log.Printf("server: tls handshake error:%v", err)
+		return
+	}
+	sc.ctx = withPeer(sc.ctx, conn)
+
+	write := func(resp []byte) {
+		sc.sending.Lock()
+		defer sc.sending.Unlock()
+		if _, err := conn.Write(resp); err != nil {
+			log.Println("rpc server: write jsonrpc response error:", err)
+		}
+	}
+	dec := json.NewDecoder(conn)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				// the stream can not be resynchronized after a syntax error
+				resp, _ := json.Marshal(newJSONRPCError(jsonrpcParseError, "parse error: "+err.Error()))
+				write(append(resp, '\n'))
+			} else if err != io.EOF && !server.shuttingDown() {
+				log.Println("rpc server: read jsonrpc request error:", err)
+			}
+			break
+		}
+		if !sc.beginRequest() {
+			break
+		}
+		server.serveJSONRPC(sc, raw, nil, func(resp []byte) {
+			if resp != nil {
+				write(append(resp, '\n'))
+			}
+			sc.endRequest()
+		})
+	}
+	// Unlike the handshaked protocol, a scripting client may close its side
+	// as soon as it has sent the requests, answer them before closing.
+	sc.wg.Wait()
+}
+
+// JSONRPCHandler returns an http.Handler that serves the JSON-RPC 2.0 request
+// or batch in the body of a POST request. A request consisting of
+// notifications only is answered with 204 No Content.
+func (server *Server) JSONRPCHandler() http.Handler {
+	return jsonrpcHandler{server}
+}
+
+// HandleJSONRPC registers the JSONRPCHandler of the server on path.
+// It is still necessary to invoke http.Serve(), typically in a go statement.
+func (server *Server) HandleJSONRPC(path string) {
+	http.Handle(path, server.JSONRPCHandler())
+}
+
+// HandleJSONRPC registers the JSONRPCHandler of DefaultServer on DefaultJSONRPCPath.
+// It is still necessary to invoke http.Serve(), typically in a go statement.
+func HandleJSONRPC() {
+	DefaultServer.HandleJSONRPC(DefaultJSONRPCPath)
+}
+
+type jsonrpcHandler struct {
+	server *Server
+}
+
+func (h jsonrpcHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 must POST\n")
+		return
+	}
+	maxSize := h.server.maxFrameSize
+	if maxSize == 0 {
+		maxSize = codec.DefaultMaxFrameSize
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, int64(maxSize)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	sc, ok := h.server.beginHTTPRequest(req)
+	if !ok {
+		http.Error(w, errServerShutdown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer h.server.endHTTPRequest(sc)
+	// the credentials of the caller reach the authenticators like those of a call
+	var md metadata.MD
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		md = metadata.Pairs(AuthorizationKey, auth)
+	}
+	var resp []byte
+	if json.Valid(body) {
+		ch := make(chan []byte, 1)
+		h.server.serveJSONRPC(sc, body, md, func(resp []byte) { ch <- resp })
+		resp = <-ch
+	} else {
+		resp, _ = json.Marshal(newJSONRPCError(jsonrpcParseError, "parse error"))
+	}
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(resp)
+}
+
+// serveJSONRPC handles a JSON-RPC message, a request or a batch of requests,
+// with the metadata md and calls done with the encoded response, nil if
+// nothing is to be answered.
+func (server *Server) serveJSONRPC(sc *serverConn, raw json.RawMessage, md metadata.MD, done func(resp []byte)) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || raw[0] != '[' {
+		server.callJSONRPC(sc, raw, md, func(resp *jsonrpcResponse) {
+			if resp == nil {
+				done(nil)
+				return
+			}
+			b, _ := json.Marshal(resp)
+			done(b)
+		})
+		return
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(raw, &batch); err != nil || len(batch) == 0 {
+		b, _ := json.Marshal(newJSONRPCError(jsonrpcInvalidRequest, "invalid request: empty batch"))
+		done(b)
+		return
+	}
+	resps := make([]*jsonrpcResponse, len(batch))
+	pending := int32(len(batch))
+	for i := range batch {
+		i := i
+		server.callJSONRPC(sc, batch[i], md, func(resp *jsonrpcResponse) {
+			resps[i] = resp
+			if atomic.AddInt32(&pending, -1) != 0 {
+				return
+			}
+			out := make([]*jsonrpcResponse, 0, len(resps))
+			for _, resp := range resps {
+				if resp != nil {
+					out = append(out, resp)
+				}
+			}
+			if len(out) == 0 {
+				done(nil)
+				return
+			}
+			b, _ := json.Marshal(out)
+			done(b)
+		})
+	}
+}
+
+// callJSONRPC starts a single JSON-RPC request with the metadata md and
+// calls done with its response, nil for a notification.
+func (server *Server) callJSONRPC(sc *serverConn, raw json.RawMessage, md metadata.MD, done func(resp *jsonrpcResponse)) {
+	var r jsonrpcRequest
+	if err := json.Unmarshal(raw, &r); err != nil || r.Version != "2.0" || r.Method == "" {
+		resp := newJSONRPCError(jsonrpcInvalidRequest, "invalid request")
+		resp.ID = r.ID
+		done(resp)
+		return
+	}
+	notify := r.ID == nil
+
+	req := &request{h: &codec.Header{ServiceMethod: r.Method}, md: md}
+	if notify {
+		req.h.Kind = codec.KindNotify
+	}
+	err := server.readJSONRPCRequest(req, r.Params)
+	if err == nil && server.shuttingDown() {
+		err = errServerShutdown
+	}
+	if err != nil {
+		if notify {
+			log.Printf("rpc server: drop notification %s: %v", r.Method, err)
+			done(nil)
+			return
+		}
+		status.Convert(err).ToHeader(req.h)
+		done(jsonrpcResponseOf(&r, req.h, nil))
+		return
+	}
+	sc.prepareRequest(req, 0)
+	server.startRequest(sc, req, 0, func(body interface{}, ok bool) {
+		if !ok {
+			done(nil)
+			return
+		}
+		done(jsonrpcResponseOf(&r, req.h, body))
+	})
+}
+
+// jsonrpcResponseOf returns the response to r, h is the header of the reply
+// and body its body.
+func jsonrpcResponseOf(r *jsonrpcRequest, h *codec.Header, body interface{}) *jsonrpcResponse {
+	resp := &jsonrpcResponse{Version: "2.0", ID: r.ID}
+	if s := status.FromHeader(h); s != nil {
+		resp.Error = statusToJSONRPC(s, h.Details)
+		return resp
+	}
+	var err error
+	if resp.Result, err = json.Marshal(body); err != nil {
+		log.Printf("rpc server: encode jsonrpc result of %s: %v", r.Method, err)
+		resp.Error = &jsonrpcError{Code: jsonrpcInternalError, Message: "rpc server: can not encode result"}
+	}
+	return resp
+}
+
+// readJSONRPCRequest finds the method of req and decodes params into its argument.
+func (server *Server) readJSONRPCRequest(req *request, params json.RawMessage) error {
+	argvi, err := server.newRequest(req)
+	if err != nil {
+		return err
+	}
+	if err := unmarshalParams(params, argvi, req.mtype.ArgType); err != nil {
+		return status.Error(codes.InvalidArgument, "rpc: invalid params: "+err.Error())
+	}
+	return nil
+}
+
+// newRequest finds the unary method of req, which is not read from a codec,
+// and allocates its argument and reply. It returns a pointer to the argument
+// to decode into.
+func (server *Server) newRequest(req *request) (argvi interface{}, err error) {
+	req.svc, req.mtype, err = server.findService(req.h.ServiceMethod)
+	if err != nil {
+		return nil, err
+	}
+	if req.mtype.stream {
+		return nil, status.Error(codes.Unimplemented, "rpc: method "+req.h.ServiceMethod+" is a streaming method")
+	}
+	req.argv = req.mtype.newArgv()
+	req.replyv = req.mtype.newReplyv()
+
+	argvi = req.argv.Interface()
+	if req.argv.Type().Kind() != reflect.Ptr {
+		argvi = req.argv.Addr().Interface()
+	}
+	return argvi, nil
+}
+
+// unmarshalParams decodes params into v, a pointer to the argument of type t.
+// A method takes a single argument, given by name as an object, or by
+// position as an array of one element. Missing params leave the zero value.
+func unmarshalParams(params json.RawMessage, v interface{}, t reflect.Type) error {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return nil
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if params[0] == '[' && t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		var list []json.RawMessage
+		if err := json.Unmarshal(params, &list); err != nil {
+			return err
+		}
+		if len(list) != 1 {
+			return fmt.Errorf("expect 1 positional param, got %d", len(list))
+		}
+		params = list[0]
+	}
+	return json.Unmarshal(params, v)
+}
@@ -0,0 +1,189 @@
+package myRPC
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Calc int
+
+func (c *Calc) Add(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (c *Calc) Total(args []int, reply *int) error {
+	for _, n := range args {
+		*reply += n
+	}
+	return nil
+}
+
+func newJSONRPCServer() (*Server, *Audit) {
+	server := NewServer()
+	a := newAudit()
+	_assert(server.Register(new(Calc)) == nil, "register Calc")
+	_assert(server.Register(new(Strict)) == nil, "register Strict")
+	_assert(server.Register(a) == nil, "register Audit")
+	return server, a
+}
+
+// jsonrpcResult is a decoded JSON-RPC response.
+type jsonrpcResult struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			Code    uint32 `json:"code"`
+			Details []struct {
+				Type  string          `json:"type"`
+				Value json.RawMessage `json:"value"`
+			} `json:"details"`
+		} `json:"data"`
+	} `json:"error"`
+	ID json.RawMessage `json:"id"`
+}
+
+func TestServer_JSONRPC(t *testing.T) {
+	server, a := newJSONRPCServer()
+	addr := startTestServer(t, server, (*Server).AcceptJSONRPC)
+
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	roundTrip := func(req string) string {
+		_, err := conn.Write([]byte(req + "\n"))
+		_assert(err == nil, "write: %v", err)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := r.ReadString('\n')
+		_assert(err == nil, "read response of %s: %v", req, err)
+		return line
+	}
+	call := func(req string) jsonrpcResult {
+		var resp jsonrpcResult
+		line := roundTrip(req)
+		_assert(json.Unmarshal([]byte(line), &resp) == nil, "decode %s", line)
+		_assert(resp.Version == "2.0", "expect version 2.0, got %s", line)
+		return resp
+	}
+
+	resp := call(`{"jsonrpc":"2.0","method":"Calc.Add","params":{"Num1":1,"Num2":2},"id":1}`)
+	_assert(resp.Error == nil && string(resp.Result) == "3" && string(resp.ID) == "1", "by name: got %+v", resp)
+	resp = call(`{"jsonrpc":"2.0","method":"Calc.Add","params":[{"Num1":3,"Num2":4}],"id":"a"}`)
+	_assert(resp.Error == nil && string(resp.Result) == "7" && string(resp.ID) == `"a"`, "by position: got %+v", resp)
+	resp = call(`{"jsonrpc":"2.0","method":"Calc.Total","params":[1,2,3],"id":null}`)
+	_assert(resp.Error == nil && string(resp.Result) == "6" && string(resp.ID) == "null", "slice param: got %+v", resp)
+
+	resp = call(`{"jsonrpc":"2.0","method":"Calc.Nope","id":2}`)
+	_assert(resp.Error != nil && resp.Error.Code == jsonrpcMethodNotFound, "expect method not found, got %+v", resp)
+	resp = call(`{"jsonrpc":"2.0","method":"Calc.Add","params":[1,2],"id":3}`)
+	_assert(resp.Error != nil && resp.Error.Code == jsonrpcInvalidParams, "expect invalid params, got %+v", resp)
+	resp = call(`{"method":"Calc.Add","id":4}`)
+	_assert(resp.Error != nil && resp.Error.Code == jsonrpcInvalidRequest && string(resp.ID) == "4", "expect invalid request, got %+v", resp)
+	resp = call(`{"jsonrpc":"2.0","method":"Strict.Check","params":[""],"id":5}`)
+	_assert(resp.Error != nil && resp.Error.Code == jsonrpcInvalidParams && resp.Error.Message == "strict: empty name" &&
+		resp.Error.Data.Code == 3 && len(resp.Error.Data.Details) == 1 &&
+		string(resp.Error.Data.Details[0].Value) == `{"Field":"name","Description":"must not be empty"}`,
+		"expect status details, got %+v", resp)
+
+	// a notification is not answered, the next line is the response of the batch
+	line := roundTrip(`{"jsonrpc":"2.0","method":"Audit.Record","params":["login"]}` +
+		`[{"jsonrpc":"2.0","method":"Calc.Add","params":{"Num1":1,"Num2":1},"id":1},` +
+		`{"jsonrpc":"2.0","method":"Audit.Record","params":["logout"]},` +
+		`{"jsonrpc":"2.0","method":"Calc.Nope","id":2}]`)
+	var batch []jsonrpcResult
+	_assert(json.Unmarshal([]byte(line), &batch) == nil, "decode batch %s", line)
+	_assert(len(batch) == 2 && string(batch[0].Result) == "2" && batch[1].Error.Code == jsonrpcMethodNotFound,
+		"expect 2 responses in the batch, got %s", line)
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-a.events:
+			got[event] = true
+		case <-time.After(time.Second):
+			t.Fatalf("notifications not handled, got %v", got)
+		}
+	}
+
+	resp = call(`[]`)
+	_assert(resp.Error != nil && resp.Error.Code == jsonrpcInvalidRequest, "empty batch: got %+v", resp)
+	resp = call(`{"jsonrpc":}`)
+	_assert(resp.Error != nil && resp.Error.Code == jsonrpcParseError, "expect parse error, got %+v", resp)
+}
+
+func TestServer_JSONRPCHandler(t *testing.T) {
+	server, _ := newJSONRPCServer()
+	ts := httptest.NewServer(server.JSONRPCHandler())
+	defer ts.Close()
+
+	post := func(body string) (*http.Response, jsonrpcResult) {
+		resp, err := http.Post(ts.URL, "application/json", strings.NewReader(body))
+		_assert(err == nil, "post: %v", err)
+		defer func() { _ = resp.Body.Close() }()
+		var result jsonrpcResult
+		if resp.StatusCode == http.StatusOK {
+			_assert(json.NewDecoder(resp.Body).Decode(&result) == nil, "decode response of %s", body)
+		}
+		return resp, result
+	}
+
+	resp, result := post(`{"jsonrpc":"2.0","method":"Calc.Add","params":{"Num1":1,"Num2":2},"id":1}`)
+	_assert(resp.StatusCode == http.StatusOK && resp.Header.Get("Content-Type") == "application/json", "got %s", resp.Status)
+	_assert(result.Error == nil && string(result.Result) == "3", "got %+v", result)
+	resp, _ = post(`{"jsonrpc":"2.0","method":"Audit.Record","params":["x"]}`)
+	_assert(resp.StatusCode == http.StatusNoContent, "expect 204 for a notification, got %s", resp.Status)
+	resp, result = post(`{"jsonrpc"`)
+	_assert(resp.StatusCode == http.StatusOK && result.Error != nil && result.Error.Code == jsonrpcParseError, "expect parse error, got %+v", result)
+
+	get, err := http.Get(ts.URL)
+	_assert(err == nil, "get: %v", err)
+	_ = get.Body.Close()
+	_assert(get.StatusCode == http.StatusMethodNotAllowed, "expect 405 for GET, got %s", get.Status)
+}
+
+func TestServer_JSONRPCHandlerShutdown(t *testing.T) {
+	server := NewServer()
+	gate := newGate()
+	_assert(server.Register(gate) == nil, "register Gate")
+	ts := httptest.NewServer(server.JSONRPCHandler())
+	defer ts.Close()
+	defer gate.open()
+	post := func() *http.Response {
+		resp, err := http.Post(ts.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","method":"Gate.Pass","params":1,"id":1}`))
+		_assert(err == nil, "post: %v", err)
+		return resp
+	}
+
+	inFlight := make(chan *http.Response, 1)
+	go func() { inFlight <- post() }()
+	<-gate.started
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned with a request in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	resp := post()
+	_ = resp.Body.Close()
+	_assert(resp.StatusCode == http.StatusServiceUnavailable, "expect 503 during shutdown, got %s", resp.Status)
+
+	gate.open()
+	resp = <-inFlight
+	var result jsonrpcResult
+	_assert(json.NewDecoder(resp.Body).Decode(&result) == nil, "decode response")
+	_ = resp.Body.Close()
+	_assert(result.Error == nil && string(result.Result) == "1", "the request in flight should be answered, got %+v", result)
+	_assert(<-shutdown == nil, "Shutdown should drain without error")
+}
@@ -0,0 +1,235 @@
+package myRPC
+
+import (
+	"context"
+	"math"
+	"net"
+	"path"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/status"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limit bounds the calls of a method or of a client. The zero value of a
+// field means no limit. A call exceeding a limit is answered with
+// codes.ResourceExhausted without reaching the method.
+type Limit struct {
+	// Rate is the number of calls per second allowed on average,
+	// Burst the number of calls allowed at once above the average.
+	// Burst defaults to Rate rounded up.
+	Rate  float64
+	Burst int
+	// MaxInFlight is the number of calls being handled at once.
+	MaxInFlight int
+}
+
+// MethodLimit returns a ServerOption that limits the calls of each method
+// matching pattern, in the syntax of path.Match such as "Arith.*". Every
+// method has limits of its own, the first MethodLimit a method matches
+// applies. It only applies to services registered after NewServer.
+func MethodLimit(pattern string, limit Limit) ServerOption {
+	return func(server *Server) {
+		server.methodLimits = append(server.methodLimits, methodLimit{pattern, limit})
+	}
+}
+
+// ClientLimit returns a ServerOption that limits the calls of each client to
+// all the methods. A client is identified by its principal, see Authentication,
+// or by the host of its address when it is anonymous.
+func ClientLimit(limit Limit) ServerOption {
+	return func(server *Server) {
+		server.clientLimits = &clientLimiters{limit: limit, limiters: make(map[string]*limiter)}
+	}
+}
+
+type methodLimit struct {
+	pattern string
+	limit   Limit
+}
+
+// limiterFor returns the limiter of the method serviceMethod, nil if it has no limits.
+func (server *Server) limiterFor(serviceMethod string) *limiter {
+	for _, ml := range server.methodLimits {
+		if ok, _ := path.Match(ml.pattern, serviceMethod); ok {
+			return newLimiter(ml.limit)
+		}
+	}
+	return nil
+}
+
+// limiter is a token bucket for the rate and a counter for the calls in flight.
+type limiter struct {
+	limit Limit
+	burst float64
+	hits  uint64 // accessed atomically, calls rejected
+
+	mu       sync.Mutex // protect following
+	tokens   float64
+	last     time.Time // when tokens was last refilled
+	inFlight int
+}
+
+func newLimiter(limit Limit) *limiter {
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(limit.Rate))
+	}
+	return &limiter{limit: limit, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// acquire takes a token and a slot for a call. It returns the error to
+// reject the call with if a limit is exceeded, what names the limited.
+func (l *limiter) acquire(now time.Time, what string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit.MaxInFlight > 0 && l.inFlight >= l.limit.MaxInFlight {
+		atomic.AddUint64(&l.hits, 1)
+		return status.Errorf(codes.ResourceExhausted, "rpc server: too many calls in flight for %s, the limit is %d", what, l.limit.MaxInFlight)
+	}
+	if l.limit.Rate > 0 {
+		l.refill(now)
+		if l.tokens < 1 {
+			atomic.AddUint64(&l.hits, 1)
+			return status.Errorf(codes.ResourceExhausted, "rpc server: rate limit of %s exceeded, the limit is %g calls per second", what, l.limit.Rate)
+		}
+		l.tokens--
+	}
+	l.inFlight++
+	return nil
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	l.inFlight--
+	l.mu.Unlock()
+}
+
+// refill adds the tokens earned since the last refill, it must be called with l.mu held.
+func (l *limiter) refill(now time.Time) {
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = math.Min(l.burst, l.tokens+elapsed.Seconds()*l.limit.Rate)
+		l.last = now
+	}
+}
+
+// idle reports whether the limiter is in its initial state, so that it can be dropped.
+func (l *limiter) idle(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(now)
+	return l.inFlight == 0 && l.tokens >= l.burst
+}
+
+// Hits returns the number of calls rejected by the limiter.
+func (l *limiter) Hits() uint64 {
+	return atomic.LoadUint64(&l.hits)
+}
+
+// clientSweepInterval is how often the limiters of idle clients are dropped.
+const clientSweepInterval = time.Minute
+
+// clientLimiters holds a limiter per client.
+type clientLimiters struct {
+	limit Limit
+
+	mu        sync.Mutex // protect following
+	limiters  map[string]*limiter
+	lastSweep time.Time
+}
+
+// get returns the limiter of the client, it drops the limiters of the idle
+// clients once in a while so that the clients gone are forgotten.
+func (c *clientLimiters) get(client string, now time.Time) *limiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastSweep) > clientSweepInterval {
+		for k, l := range c.limiters {
+			if l.idle(now) {
+				delete(c.limiters, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	l, ok := c.limiters[client]
+	if !ok {
+		l = newLimiter(c.limit)
+		c.limiters[client] = l
+	}
+	return l
+}
+
+// clientHits is the number of calls of a client rejected by the limits.
+type clientHits struct {
+	Client string
+	Hits   uint64
+}
+
+// hits returns the clients with calls rejected, the most rejected first.
+func (c *clientLimiters) hits() []clientHits {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var hits []clientHits
+	for k, l := range c.limiters {
+		if n := l.Hits(); n > 0 {
+			hits = append(hits, clientHits{k, n})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Hits != hits[j].Hits {
+			return hits[i].Hits > hits[j].Hits
+		}
+		return hits[i].Client < hits[j].Client
+	})
+	return hits
+}
+
+// clientOf returns the name the client of the call handled with ctx is limited by.
+func clientOf(ctx context.Context) string {
+	if p, ok := PrincipalFromContext(ctx); ok {
+		return "principal " + p.Name
+	}
+	if p, ok := PeerFromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			return p.Addr.String()
+		}
+		return host
+	}
+	return "unknown"
+}
+
+// limit applies the limits of the method mtype and of the client to the call
+// handled with ctx. The returned release must be called once the call is done.
+func (server *Server) limit(ctx context.Context, mtype *methodType, serviceMethod string) (release func(), err error) {
+	if mtype.limiter == nil && server.clientLimits == nil {
+		return func() {}, nil
+	}
+	now := time.Now()
+	var limiters []*limiter
+	release = func() {
+		for _, l := range limiters {
+			l.release()
+		}
+	}
+	if mtype.limiter != nil {
+		if err := mtype.limiter.acquire(now, serviceMethod); err != nil {
+			atomic.AddUint64(&mtype.numLimited, 1)
+			return nil, err
+		}
+		limiters = append(limiters, mtype.limiter)
+	}
+	if server.clientLimits != nil {
+		client := clientOf(ctx)
+		l := server.clientLimits.get(client, now)
+		if err := l.acquire(now, client); err != nil {
+			atomic.AddUint64(&mtype.numLimited, 1)
+			release()
+			return nil, err
+		}
+		limiters = append(limiters, l)
+	}
+	return release, nil
+}
@@ -0,0 +1,102 @@
+package myRPC
+
+import (
+	"context"
+	"net/http/httptest"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/metadata"
+	"rpc/myRPC/status"
+	"strings"
+	"testing"
+	"time"
+)
+
+func numLimited(server *Server, serviceName, method string) uint64 {
+	svc, _ := server.serviceMap.Load(serviceName)
+	return svc.(*service).method[method].NumLimited()
+}
+
+func TestServer_MethodLimit(t *testing.T) {
+	server := NewServer(
+		MethodLimit("Tally.Sleep", Limit{MaxInFlight: 1}),
+		MethodLimit("Calc.*", Limit{Rate: 1, Burst: 2}),
+	)
+	addr := startTestServer(t, server, (*Server).Accept, new(Calc), new(Tally))
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	// one call in flight at a time
+	var n int
+	slow := client.Go("Tally.Sleep", 200, &n, nil)
+	time.Sleep(50 * time.Millisecond)
+	err = client.Call(ctx, "Tally.Sleep", 0, &n)
+	_assert(status.Code(err) == codes.ResourceExhausted, "expect ResourceExhausted in flight, got %v", err)
+	_assert((<-slow.Done).Error == nil, "slow call: %v", slow.Error)
+	err = client.Call(ctx, "Tally.Sleep", 0, &n)
+	_assert(err == nil, "expect a call once the slow one is done, got %v", err)
+	_assert(numLimited(server, "Tally", "Sleep") == 1, "expect 1 limited call")
+
+	// a burst of 2, then 1 call per second
+	var sum int
+	for i := 0; i < 2; i++ {
+		err = client.Call(ctx, "Calc.Add", Args{Num1: i, Num2: 1}, &sum)
+		_assert(err == nil, "call %d within the burst: %v", i, err)
+	}
+	err = client.Call(ctx, "Calc.Add", Args{Num1: 1, Num2: 1}, &sum)
+	_assert(status.Code(err) == codes.ResourceExhausted, "expect ResourceExhausted for the rate, got %v", err)
+	// every method has a bucket of its own
+	err = client.Call(ctx, "Calc.Total", []int{1, 2}, &sum)
+	_assert(err == nil && sum == 3, "expect Calc.Total not limited by Calc.Add, got %d %v", sum, err)
+
+	w := httptest.NewRecorder()
+	debugHTTP{server}.ServeHTTP(w, httptest.NewRequest("GET", "/debug/rpc", nil))
+	_assert(strings.Contains(w.Body.String(), "Limited"), "debug page should show limited calls")
+}
+
+func TestServer_ClientLimit(t *testing.T) {
+	server := NewServer(
+		Authentication(BearerTokens(map[string]string{"tok-a": "alice", "tok-b": "bob"})),
+		ClientLimit(Limit{Rate: 0.1, Burst: 1}),
+	)
+	addr := startTestServer(t, server, (*Server).Accept, new(Calc), new(Tally))
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+	call := func(token string) error {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), AuthorizationKey, "Bearer "+token)
+		var sum int
+		return client.Call(ctx, "Calc.Add", Args{Num1: 1, Num2: 2}, &sum)
+	}
+
+	_assert(call("tok-a") == nil, "first call of alice")
+	err = call("tok-a")
+	_assert(status.Code(err) == codes.ResourceExhausted, "expect ResourceExhausted for alice, got %v", err)
+	// the clients are limited apart, even on the same connection
+	_assert(call("tok-b") == nil, "first call of bob")
+	_assert(numLimited(server, "Calc", "Add") == 1, "expect 1 limited call")
+
+	w := httptest.NewRecorder()
+	debugHTTP{server}.ServeHTTP(w, httptest.NewRequest("GET", "/debug/rpc", nil))
+	_assert(strings.Contains(w.Body.String(), "principal alice"), "debug page should show the limited client")
+	_assert(!strings.Contains(w.Body.String(), "principal bob"), "debug page should not show bob")
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := newLimiter(Limit{Rate: 10, Burst: 1, MaxInFlight: 2})
+	l.last = now
+	_assert(l.acquire(now, "x") == nil, "expect the burst")
+	_assert(l.acquire(now, "x") != nil, "expect the rate limit")
+	now = now.Add(100 * time.Millisecond)
+	_assert(l.acquire(now, "x") == nil, "expect a token after 100ms")
+	now = now.Add(time.Second)
+	_assert(l.acquire(now, "x") != nil, "expect the in-flight limit")
+	l.release()
+	_assert(l.acquire(now, "x") == nil, "expect a slot once released")
+	_assert(l.Hits() == 2, "expect 2 hits, got %d", l.Hits())
+	l.release()
+	l.release()
+	_assert(l.idle(now.Add(time.Second)), "expect the limiter to be idle")
+}
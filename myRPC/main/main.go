@@ -42,8 +42,9 @@ func main() {
 			B: i + 1,
 		}
 		var reply Reply
-		ctx, _ := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 		err := client.Call(ctx, ServiceMethod, &args, &reply)
+		cancel()
 		if err != nil {
 			log.Println(err)
 		}
@@ -0,0 +1,43 @@
+package myRPC
+
+import (
+	"context"
+	"errors"
+	"rpc/myRPC/metadata"
+	"sync"
+)
+
+// trailer collects the metadata a handler sets with SetTrailer,
+// it is sent back with the reply.
+type trailer struct {
+	mu sync.Mutex
+	md metadata.MD
+}
+
+func (tr *trailer) get() metadata.MD {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.md
+}
+
+type trailerKey struct{}
+type trailerOutKey struct{}
+
+// SetTrailer sets the trailer metadata sent back with the reply of the call
+// handled with ctx. It may be called more than once, all the metadata is merged.
+func SetTrailer(ctx context.Context, md metadata.MD) error {
+	tr, ok := ctx.Value(trailerKey{}).(*trailer)
+	if !ok {
+		return errors.New("rpc: SetTrailer: no call found in the context")
+	}
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.md = metadata.Join(tr.md, md)
+	return nil
+}
+
+// WithTrailer returns a context that makes Client.Call store the trailer
+// metadata of the reply in md.
+func WithTrailer(ctx context.Context, md *metadata.MD) context.Context {
+	return context.WithValue(ctx, trailerOutKey{}, md)
+}
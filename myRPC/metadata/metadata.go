@@ -0,0 +1,112 @@
+// Package metadata carries string key-value pairs alongside an RPC,
+// such as request IDs, auth tokens or trace context.
+//
+// A client attaches metadata to the outgoing context of a call, the server
+// reads it from the incoming context of the handler. Keys are case
+// insensitive and stored in lower case.
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// MD is a mapping from metadata keys to values.
+type MD map[string]string
+
+// New creates an MD from a given key-value map.
+func New(m map[string]string) MD {
+	md := make(MD, len(m))
+	for k, v := range m {
+		md[strings.ToLower(k)] = v
+	}
+	return md
+}
+
+// Pairs returns an MD formed by the mapping of key, value ...
+// Pairs panics if len(kv) is odd. A later value replaces an earlier one
+// with the same key.
+func Pairs(kv ...string) MD {
+	if len(kv)%2 == 1 {
+		panic(fmt.Sprintf("metadata: Pairs got the odd number of input pairs for metadata: %d", len(kv)))
+	}
+	md := make(MD, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		md[strings.ToLower(kv[i])] = kv[i+1]
+	}
+	return md
+}
+
+// Len returns the number of items in md.
+func (md MD) Len() int {
+	return len(md)
+}
+
+// Copy returns a copy of md.
+func (md MD) Copy() MD {
+	return Join(md)
+}
+
+// Get returns the value for the key, or "" if there is none.
+func (md MD) Get(k string) string {
+	return md[strings.ToLower(k)]
+}
+
+// Set sets the value of the key.
+func (md MD) Set(k, v string) {
+	md[strings.ToLower(k)] = v
+}
+
+// Delete removes the key.
+func (md MD) Delete(k string) {
+	delete(md, strings.ToLower(k))
+}
+
+// Join joins any number of mds into a single MD, a value in a later md
+// replaces the one in an earlier md with the same key.
+func Join(mds ...MD) MD {
+	out := MD{}
+	for _, md := range mds {
+		for k, v := range md {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+type mdIncomingKey struct{}
+type mdOutgoingKey struct{}
+
+// NewIncomingContext creates a new context with incoming md attached.
+// It is used by the server, handlers read it with FromIncomingContext.
+func NewIncomingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, mdIncomingKey{}, md)
+}
+
+// NewOutgoingContext creates a new context with outgoing md attached.
+// The client sends it with every call made with the context.
+func NewOutgoingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, mdOutgoingKey{}, md)
+}
+
+// AppendToOutgoingContext returns a new context with the provided kv merged
+// with any existing metadata in the context.
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	md, _ := FromOutgoingContext(ctx)
+	return NewOutgoingContext(ctx, Join(md, Pairs(kv...)))
+}
+
+// FromIncomingContext returns the incoming metadata in ctx if it exists.
+// The returned MD should not be modified.
+func FromIncomingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(mdIncomingKey{}).(MD)
+	return md, ok
+}
+
+// FromOutgoingContext returns the outgoing metadata in ctx if it exists.
+// The returned MD should not be modified.
+func FromOutgoingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(mdOutgoingKey{}).(MD)
+	return md, ok
+}
@@ -0,0 +1,67 @@
+package myRPC
+
+import (
+	"context"
+	"fmt"
+	pb "rpc/grpc/1.introduction/proto"
+	"rpc/myRPC/codec"
+	"rpc/myRPC/metadata"
+	"testing"
+)
+
+// Meta echoes the incoming metadata back as trailer.
+type Meta int
+
+func (m *Meta) Echo(ctx context.Context, args string, reply *string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	*reply = args + " " + md.Get("request-id")
+	return SetTrailer(ctx, metadata.Pairs("request-id", md.Get("request-id"), "served-by", "meta"))
+}
+
+func (m *Meta) Hello(ctx context.Context, args *pb.HelloRequest, reply *pb.HelloReply) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	reply.Message = "Hello " + args.Name + " " + md.Get("request-id")
+	return SetTrailer(ctx, metadata.Pairs("served-by", "meta"))
+}
+
+func TestMetadata_RoundTrip(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, new(Meta))
+	for _, opt := range codecOptions() {
+		opt := opt
+		t.Run(fmt.Sprintf("%s binary=%v", opt.CodeType, opt.BinaryHandshake), func(t *testing.T) {
+			client, err := Dial("tcp", addr, &opt)
+			_assert(err == nil, "dial: %v", err)
+			defer func() { _ = client.Close() }()
+
+			ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("Request-ID", "42"))
+			var tr metadata.MD
+			var reply string
+			err = client.Call(WithTrailer(ctx, &tr), "Meta.Echo", "hi", &reply)
+			_assert(err == nil && reply == "hi 42", "call Meta.Echo: %v %q", err, reply)
+			_assert(tr.Get("request-id") == "42" && tr.Get("served-by") == "meta", "wrong trailer %v", tr)
+
+			// metadata belongs to a single call
+			tr = nil
+			err = client.Call(WithTrailer(context.Background(), &tr), "Meta.Echo", "hi", &reply)
+			_assert(err == nil && reply == "hi ", "call Meta.Echo without metadata: %v %q", err, reply)
+			_assert(tr.Get("request-id") == "" && tr.Get("served-by") == "meta", "wrong trailer %v", tr)
+		})
+	}
+
+	opt := DefaultOption
+	opt.CodeType = codec.ProtobufType
+	client, err := Dial("tcp", addr, &opt)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "request-id", "7")
+	var tr metadata.MD
+	var reply pb.HelloReply
+	err = client.Call(WithTrailer(ctx, &tr), "Meta.Hello", &pb.HelloRequest{Name: "myRPC"}, &reply)
+	_assert(err == nil && reply.Message == "Hello myRPC 7", "call Meta.Hello: %v %q", err, reply.Message)
+	_assert(tr.Get("served-by") == "meta", "wrong trailer %v", tr)
+}
+
+func TestSetTrailer_NoCall(t *testing.T) {
+	err := SetTrailer(context.Background(), metadata.Pairs("k", "v"))
+	_assert(err != nil, "expect an error outside of a call")
+}
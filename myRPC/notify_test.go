@@ -0,0 +1,92 @@
+package myRPC
+
+import (
+	"context"
+	"errors"
+	"net"
+	"rpc/myRPC/codec"
+	"testing"
+	"time"
+)
+
+// Audit records the events it is notified of.
+type Audit struct {
+	events chan string
+}
+
+func (a *Audit) Record(args string, reply *struct{}) error {
+	a.events <- args
+	return nil
+}
+
+func (a *Audit) Fail(args string, reply *struct{}) error {
+	return errors.New("audit: fail")
+}
+
+func (a *Audit) Count(args int, reply *int) error {
+	*reply = args
+	return nil
+}
+
+func newAudit() *Audit {
+	return &Audit{events: make(chan string, 100)}
+}
+
+func TestClient_Notify(t *testing.T) {
+	a := newAudit()
+	addr := startTestServer(t, NewServer(), (*Server).Accept, a)
+	for _, opt := range codecOptions() {
+		client, err := Dial("tcp", addr, &opt)
+		_assert(err == nil, "dial: %v", err)
+		for _, event := range []string{"login", "logout"} {
+			_assert(client.Notify("Audit.Record", event) == nil, "notify %s", event)
+		}
+		// notifications are handled concurrently like calls, in any order
+		got := map[string]bool{}
+		for i := 0; i < 2; i++ {
+			select {
+			case event := <-a.events:
+				got[event] = true
+			case <-time.After(time.Second):
+				t.Fatalf("%s: events not recorded, got %v", opt.CodeType, got)
+			}
+		}
+		_assert(got["login"] && got["logout"], "expect login and logout, got %v", got)
+		client.mu.Lock()
+		pending := len(client.pending)
+		client.mu.Unlock()
+		_assert(pending == 0, "notifications should not be pending, got %d", pending)
+		_ = client.Close()
+		_assert(client.Notify("Audit.Record", "closed") == ErrShutdown, "expect ErrShutdown after Close")
+	}
+}
+
+func TestClient_NotifyNoReply(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, newAudit())
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = conn.Close() }()
+	opt := DefaultOption
+	opt.BinaryHandshake = true
+	_assert(writeHandshake(conn, &opt) == nil, "handshake")
+	cc, err := newCodec(conn, &opt, 0)
+	_assert(err == nil, "codec: %v", err)
+
+	// neither a notification nor its error is answered,
+	// so the first reply read is the one of the call
+	_assert(cc.Write(&codec.Header{ServiceMethod: "Audit.Fail", Kind: codec.KindNotify}, "x") == nil, "write notification")
+	_assert(cc.Write(&codec.Header{ServiceMethod: "Audit.Nope", Kind: codec.KindNotify}, "x") == nil, "write notification")
+	_assert(cc.Write(&codec.Header{ServiceMethod: "Audit.Count", Seq: 7}, 3) == nil, "write call")
+	var h codec.Header
+	_assert(cc.ReadHeader(&h) == nil, "read header")
+	var n int
+	_assert(cc.ReadBody(&n) == nil, "read body")
+	_assert(h.Seq == 7 && h.Error == "" && n == 3, "expect the reply of the call, got %+v %d", h, n)
+
+	var reply int
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+	err = client.Call(context.Background(), "Audit.Count", 5, &reply)
+	_assert(err == nil && reply == 5, "call Audit.Count: %v %d", err, reply)
+}
@@ -0,0 +1,80 @@
+package myRPC
+
+import (
+	"context"
+	"net/http/httptest"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/status"
+	"strings"
+	"testing"
+)
+
+type Crash int
+
+func (c *Crash) Boom(args string, reply *string) error {
+	panic("secret: " + args)
+}
+
+func (c *Crash) Echo(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+func TestServer_RecoversPanic(t *testing.T) {
+	server := NewServer()
+	addr := startTestServer(t, server, (*Server).Accept, new(Crash))
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err = client.Call(context.Background(), "Crash.Boom", "password", &reply)
+	_assert(status.Code(err) == codes.Internal, "expect Internal, got %v", err)
+	_assert(!strings.Contains(err.Error(), "password"), "panic value leaked to the caller: %v", err)
+
+	// the connection and the server survive the panic
+	err = client.Call(context.Background(), "Crash.Echo", "still here", &reply)
+	_assert(err == nil && reply == "still here", "call after panic: %v", err)
+
+	svc, _ := server.serviceMap.Load("Crash")
+	m := svc.(*service).method["Boom"]
+	_assert(m.NumPanics() == 1 && m.NumCalls() == 1, "expect 1 panic in 1 call, got %d in %d", m.NumPanics(), m.NumCalls())
+	w := httptest.NewRecorder()
+	debugHTTP{server}.ServeHTTP(w, httptest.NewRequest("GET", "/debug/rpc", nil))
+	_assert(strings.Contains(w.Body.String(), "Panics"), "debug page should show panics")
+}
+
+func TestServer_PanicHandler(t *testing.T) {
+	got := make(chan interface{}, 1)
+	server := NewServer(PanicHandler(func(ctx context.Context, info *UnaryServerInfo, p interface{}) error {
+		got <- p
+		return status.Errorf(codes.Aborted, "%s crashed", info.ServiceMethod)
+	}))
+	addr := startTestServer(t, server, (*Server).Accept, new(Crash))
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err = client.Call(context.Background(), "Crash.Boom", "x", &reply)
+	_assert(status.Code(err) == codes.Aborted && err.Error() == "Crash.Boom crashed", "expect Aborted, got %v", err)
+	p := <-got
+	_assert(p == "secret: x", "panic handler got %v", p)
+}
+
+func TestServer_RecoversInterceptorPanic(t *testing.T) {
+	server := NewServer(UnaryInterceptor(func(ctx context.Context, args, reply interface{}, info *UnaryServerInfo, handler UnaryHandler) error {
+		if info.ServiceMethod == "Crash.Echo" {
+			panic("interceptor")
+		}
+		return handler(ctx, args, reply)
+	}))
+	addr := startTestServer(t, server, (*Server).Accept, new(Crash))
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err = client.Call(context.Background(), "Crash.Echo", "x", &reply)
+	_assert(status.Code(err) == codes.Internal, "expect Internal, got %v", err)
+}
@@ -0,0 +1,212 @@
+package myRPC
+
+import (
+	"rpc/myRPC/codes"
+	"rpc/myRPC/status"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShedPolicy chooses the request a worker pool rejects when its queue is full.
+type ShedPolicy int
+
+const (
+	// ShedNewest rejects the request arriving at a full queue.
+	ShedNewest ShedPolicy = iota
+	// ShedOldest rejects the request queued for the longest time to make room
+	// for the new one, the oldest is the most likely to be useless by now.
+	ShedOldest
+	// ShedDeadline rejects the queued requests whose deadline has passed, and
+	// if the queue is still full the request, queued or new, with the earliest
+	// deadline, which is the least likely to be served in time. Requests
+	// without a deadline are rejected last, the newest first.
+	ShedDeadline
+)
+
+func (p ShedPolicy) String() string {
+	switch p {
+	case ShedNewest:
+		return "newest"
+	case ShedOldest:
+		return "oldest"
+	case ShedDeadline:
+		return "deadline"
+	}
+	return "unknown"
+}
+
+// WorkerPool returns a ServerOption that handles the requests of the
+// connections on a fixed number of workers instead of a goroutine per
+// request. Requests wait in a queue of queueDepth while all the workers are
+// busy, a request arriving at a full queue is shed by policy and answered
+// with codes.ResourceExhausted.
+//
+// The methods run on the workers. A method that ignores the cancellation of
+// its context keeps its worker busy, but a request timing out is answered on
+// time. Streams, the requests of a batch, JSON-RPC and gateway requests are
+// queued alike.
+func WorkerPool(workers, queueDepth int, policy ShedPolicy) ServerOption {
+	return func(server *Server) {
+		if workers <= 0 {
+			workers = 1
+		}
+		if queueDepth < 0 {
+			queueDepth = 0
+		}
+		server.pool = newWorkerPool(workers, queueDepth, policy)
+	}
+}
+
+// task is a request waiting for a worker.
+type task struct {
+	run      func()
+	reject   func(err error) // answers the request with err instead of running it
+	deadline time.Time       // deadline of the caller, zero means none
+}
+
+// workerPool runs tasks on workers goroutines.
+type workerPool struct {
+	workers int
+	depth   int
+	policy  ShedPolicy
+	shed    uint64 // accessed atomically, tasks rejected
+
+	mu     sync.Mutex // protect following
+	cond   *sync.Cond // signaled when a task is queued or the pool is closed
+	queue  []*task
+	idle   int // workers waiting for a task
+	closed bool
+}
+
+func newWorkerPool(workers, depth int, policy ShedPolicy) *workerPool {
+	p := &workerPool{workers: workers, depth: depth, policy: policy}
+	p.cond = sync.NewCond(&p.mu)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+var (
+	errQueueFull   = status.Error(codes.ResourceExhausted, "rpc server: too many requests queued")
+	errQueueExpire = status.Error(codes.DeadlineExceeded, "rpc server: request deadline exceeded in the queue")
+)
+
+// submit queues t, or rejects t or a queued task by the policy if the queue
+// is full. It never blocks on the workers.
+func (p *workerPool) submit(t *task) {
+	var rejected []*task
+	var err error = errQueueFull
+	p.mu.Lock()
+	switch {
+	case p.closed:
+		rejected, err = []*task{t}, errServerShutdown
+	case p.idle > len(p.queue) || len(p.queue) < p.depth:
+		p.queue = append(p.queue, t)
+		p.cond.Signal()
+	default:
+		rejected, err = p.shedLocked(t)
+	}
+	p.mu.Unlock()
+	for _, t := range rejected {
+		atomic.AddUint64(&p.shed, 1)
+		t.reject(err)
+	}
+}
+
+// shedLocked makes room for t in the full queue. It returns the tasks to
+// reject and the error to reject them with, t is among them if it is not queued.
+func (p *workerPool) shedLocked(t *task) ([]*task, error) {
+	switch p.policy {
+	case ShedOldest:
+		if len(p.queue) == 0 {
+			return []*task{t}, errQueueFull
+		}
+		oldest := p.queue[0]
+		p.queue = append(p.queue[1:], t)
+		return []*task{oldest}, errQueueFull
+	case ShedDeadline:
+		now := time.Now()
+		queue := p.queue[:0]
+		var expired []*task
+		for _, q := range p.queue {
+			if !q.deadline.IsZero() && !now.Before(q.deadline) {
+				expired = append(expired, q)
+			} else {
+				queue = append(queue, q)
+			}
+		}
+		p.queue = queue
+		if len(expired) > 0 {
+			p.queue = append(p.queue, t)
+			return expired, errQueueExpire
+		}
+		earliest := -1
+		for i, q := range p.queue {
+			if !q.deadline.IsZero() && (earliest < 0 || q.deadline.Before(p.queue[earliest].deadline)) {
+				earliest = i
+			}
+		}
+		if earliest < 0 || (!t.deadline.IsZero() && !t.deadline.After(p.queue[earliest].deadline)) {
+			return []*task{t}, errQueueFull
+		}
+		q := p.queue[earliest]
+		p.queue = append(append(p.queue[:earliest], p.queue[earliest+1:]...), t)
+		return []*task{q}, errQueueFull
+	}
+	return []*task{t}, errQueueFull
+}
+
+func (p *workerPool) work() {
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && !p.closed {
+			p.idle++
+			p.cond.Wait()
+			p.idle--
+		}
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		t := p.queue[0]
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+		t.run()
+	}
+}
+
+// close stops the workers once they are done with their tasks,
+// the tasks still queued are rejected.
+func (p *workerPool) close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	queue := p.queue
+	p.queue = nil
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	for _, t := range queue {
+		t.reject(errServerShutdown)
+	}
+}
+
+// poolStats is the state of a worker pool shown on the debug page.
+type poolStats struct {
+	Workers int
+	Depth   int
+	Policy  ShedPolicy
+	Queued  int
+	Shed    uint64
+}
+
+func (p *workerPool) stats() *poolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return &poolStats{Workers: p.workers, Depth: p.depth, Policy: p.policy, Queued: len(p.queue), Shed: atomic.LoadUint64(&p.shed)}
+}
@@ -0,0 +1,226 @@
+package myRPC
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/status"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockPool returns a pool whose only worker is busy until the returned function is called.
+func blockPool(depth int, policy ShedPolicy) (*workerPool, func()) {
+	p := newWorkerPool(1, depth, policy)
+	started, unblock := make(chan struct{}), make(chan struct{})
+	p.submit(&task{run: func() {
+		close(started)
+		<-unblock
+	}, reject: func(error) {}})
+	<-started
+	return p, func() { close(unblock) }
+}
+
+// poolRecorder records the tasks of a pool that run and those rejected.
+type poolRecorder struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	ran      []string
+	rejected map[string]error
+}
+
+func (r *poolRecorder) task(name string, deadline time.Time) *task {
+	r.wg.Add(1)
+	return &task{
+		run: func() {
+			defer r.wg.Done()
+			r.mu.Lock()
+			r.ran = append(r.ran, name)
+			r.mu.Unlock()
+		},
+		reject: func(err error) {
+			defer r.wg.Done()
+			r.mu.Lock()
+			if r.rejected == nil {
+				r.rejected = make(map[string]error)
+			}
+			r.rejected[name] = err
+			r.mu.Unlock()
+		},
+		deadline: deadline,
+	}
+}
+
+type namedTask struct {
+	name     string
+	deadline time.Time
+}
+
+func TestWorkerPool_Shed(t *testing.T) {
+	now := time.Now()
+	for _, c := range []struct {
+		policy   ShedPolicy
+		tasks    []namedTask
+		ran      string
+		rejected string
+		code     codes.Code
+	}{
+		{ShedNewest, []namedTask{{"a", time.Time{}}, {"b", time.Time{}}, {"c", time.Time{}}}, "a b", "c", codes.ResourceExhausted},
+		{ShedOldest, []namedTask{{"a", time.Time{}}, {"b", time.Time{}}, {"c", time.Time{}}}, "b c", "a", codes.ResourceExhausted},
+		{ShedDeadline, []namedTask{{"a", now.Add(time.Hour)}, {"b", now.Add(time.Minute)}, {"c", time.Time{}}}, "a c", "b", codes.ResourceExhausted},
+		{ShedDeadline, []namedTask{{"a", now.Add(time.Hour)}, {"b", time.Time{}}, {"c", now.Add(time.Minute)}}, "a b", "c", codes.ResourceExhausted},
+		{ShedDeadline, []namedTask{{"a", time.Time{}}, {"b", now.Add(-time.Second)}, {"c", now.Add(time.Minute)}}, "a c", "b", codes.DeadlineExceeded},
+		{ShedDeadline, []namedTask{{"a", time.Time{}}, {"b", time.Time{}}, {"c", time.Time{}}}, "a b", "c", codes.ResourceExhausted},
+	} {
+		p, unblock := blockPool(2, c.policy)
+		r := new(poolRecorder)
+		for _, tk := range c.tasks {
+			p.submit(r.task(tk.name, tk.deadline))
+		}
+		unblock()
+		r.wg.Wait()
+		_assert(strings.Join(r.ran, " ") == c.ran, "%s: expect %s to run, got %v", c.policy, c.ran, r.ran)
+		err := r.rejected[c.rejected]
+		_assert(len(r.rejected) == 1 && status.Code(err) == c.code, "%s: expect %s rejected with %s, got %v", c.policy, c.rejected, c.code, r.rejected)
+		_assert(p.stats().Shed == 1, "%s: expect 1 shed", c.policy)
+		p.close()
+	}
+}
+
+func TestWorkerPool_Close(t *testing.T) {
+	p, unblock := blockPool(1, ShedNewest)
+	r := new(poolRecorder)
+	p.submit(r.task("queued", time.Time{}))
+	p.close()
+	p.submit(r.task("late", time.Time{}))
+	unblock()
+	r.wg.Wait()
+	_assert(len(r.ran) == 0, "expect nothing to run after close, got %v", r.ran)
+	_assert(errors.Is(r.rejected["queued"], errServerShutdown) && errors.Is(r.rejected["late"], errServerShutdown),
+		"expect shutdown errors, got %v", r.rejected)
+}
+
+func TestServer_WorkerPool(t *testing.T) {
+	server := NewServer(WorkerPool(1, 1, ShedNewest))
+	addr := startTestServer(t, server, (*Server).Accept, new(Calc), new(Tally))
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var a, b, c int
+	slow := client.Go("Tally.Sleep", 200, &a, nil)
+	time.Sleep(50 * time.Millisecond)
+	queued := client.Go("Tally.Sleep", 1, &b, nil)
+	time.Sleep(20 * time.Millisecond)
+	err = client.Call(context.Background(), "Tally.Sleep", 2, &c)
+	_assert(status.Code(err) == codes.ResourceExhausted, "expect ResourceExhausted for a full queue, got %v", err)
+	_assert((<-slow.Done).Error == nil && a == 200, "slow call: %v", slow.Error)
+	_assert((<-queued.Done).Error == nil && b == 1, "queued call: %v", queued.Error)
+
+	// many calls are served by the single worker as the queue drains
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var sum int
+			for {
+				err := client.Call(context.Background(), "Calc.Add", Args{Num1: i, Num2: 1}, &sum)
+				if status.Code(err) == codes.ResourceExhausted {
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				_assert(err == nil && sum == i+1, "expect %d, got %d %v", i+1, sum, err)
+				return
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	w := httptest.NewRecorder()
+	debugHTTP{server}.ServeHTTP(w, httptest.NewRequest("GET", "/debug/rpc", nil))
+	_assert(strings.Contains(w.Body.String(), "Worker pool"), "debug page should show the worker pool")
+}
+
+func TestServer_WorkerPoolTimeout(t *testing.T) {
+	addr := startTestServer(t, NewServer(WorkerPool(2, 4, ShedDeadline)), (*Server).Accept, new(Calc), new(Tally))
+	opt := DefaultOption
+	opt.HandleTimeout = 50 * time.Millisecond
+	client, err := Dial("tcp", addr, &opt)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// the worker waits for the method, the timeout is reported on time
+	var n int
+	start := time.Now()
+	err = client.Call(context.Background(), "Tally.Sleep", 1000, &n)
+	_assert(status.Code(err) == codes.DeadlineExceeded, "expect DeadlineExceeded, got %v", err)
+	_assert(time.Since(start) < 500*time.Millisecond, "expect the timeout before the method returns, took %s", time.Since(start))
+	err = client.Call(context.Background(), "Tally.Sleep", 0, &n)
+	_assert(err == nil, "expect a call after the timeout, got %v", err)
+}
+
+func TestServer_WorkerPoolGatewayTimeout(t *testing.T) {
+	server := NewServer(WorkerPool(2, 4, ShedDeadline))
+	_assert(server.Register(new(Tally)) == nil, "register Tally")
+	t.Cleanup(func() { _ = server.Close() })
+	ts := httptest.NewServer(server.GatewayHandler(DefaultGatewayPath))
+	defer ts.Close()
+
+	// the caller of an HTTP request waits for the Rpc-Timeout, not for the method
+	req, err := http.NewRequest("POST", ts.URL+DefaultGatewayPath+"Tally.Sleep", strings.NewReader("1000"))
+	_assert(err == nil, "new request: %v", err)
+	req.Header.Set(TimeoutHeader, "50ms")
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	_assert(err == nil, "post: %v", err)
+	_ = resp.Body.Close()
+	_assert(resp.StatusCode == http.StatusGatewayTimeout, "expect 504, got %s", resp.Status)
+	_assert(time.Since(start) < 500*time.Millisecond, "expect the timeout before the method returns, took %s", time.Since(start))
+}
+
+func TestServer_WorkerPoolBatch(t *testing.T) {
+	tally := new(Tally)
+	addr := startTestServer(t, NewServer(WorkerPool(2, 4, ShedNewest)), (*Server).Accept, tally)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// each request of the batch is a task of its own
+	calls := []*Call{
+		{ServiceMethod: "Tally.Sleep", Args: 100, Reply: new(int)},
+		{ServiceMethod: "Tally.Sleep", Args: 0, Reply: new(int)},
+	}
+	_assert(client.Batch(context.Background(), calls) == nil, "batch")
+	_assert(calls[0].Error == nil && calls[1].Error == nil, "expect the calls to succeed, got %v %v", calls[0].Error, calls[1].Error)
+	order := tally.reset()
+	_assert(len(order) == 2 && order[0] == 0 && order[1] == 100, "expect concurrent calls to finish as [0 100], got %v", order)
+}
+
+func TestServer_WorkerPoolCancelQueued(t *testing.T) {
+	tally := new(Tally)
+	addr := startTestServer(t, NewServer(WorkerPool(1, 4, ShedNewest)), (*Server).Accept, tally)
+	// cancel frames need the binary handshake
+	opt := DefaultOption
+	opt.BinaryHandshake = true
+	client, err := Dial("tcp", addr, &opt)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// the only worker is busy, the second call waits in the queue when it is cancelled
+	var a, b, c int
+	slow := client.Go("Tally.Sleep", 200, &a, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err = client.Call(ctx, "Tally.Sleep", 7, &b)
+	_assert(status.Code(err) == codes.Canceled, "expect Canceled, got %v", err)
+	_assert((<-slow.Done).Error == nil, "slow call: %v", slow.Error)
+	_assert(client.Call(context.Background(), "Tally.Sleep", 1, &c) == nil, "call after the cancelled one")
+
+	order := tally.reset()
+	_assert(len(order) == 2 && order[0] == 200 && order[1] == 1, "the cancelled call must not run, got %v", order)
+}
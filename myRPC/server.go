@@ -1,6 +1,9 @@
 package myRPC
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,8 +13,13 @@ import (
 	"net/http"
 	"reflect"
 	"rpc/myRPC/codec"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/metadata"
+	"rpc/myRPC/status"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -33,6 +41,24 @@ type Option struct {
 	CodeType          codec.Type    // client may choose different Codec to encode body
 	ConnectionTimeout time.Duration // 0 means no limit
 	HandleTimeout     time.Duration
+	Compression       codec.CompressType // compress messages in both directions, empty means no compression
+	CompressThreshold int                // messages shorter than it are not compressed, 0 means codec.DefaultCompressThreshold
+	// BinaryHandshake sends the option in a binary frame and frames the
+	// messages with codec.NewFrameCodec, which bounds their size. Only set it
+	// for servers that speak the binary framing, the default JSON option
+	// and codec framing work with every server. Calls and streams cancelled
+	// by the client are only stopped on the server with the binary framing,
+	// otherwise the client just stops waiting for them.
+	BinaryHandshake bool
+	// UnaryInterceptor intercepts Call and Go on the client, it stays on the client side.
+	UnaryInterceptor UnaryClientInterceptor `json:"-"`
+	// Credentials are sent to the server in the handshake, such as "Bearer <token>",
+	// and authenticate all the calls of the connection. They are sent in the
+	// clear, use them with TLSConfig only.
+	Credentials string `json:",omitempty"`
+	// TLSConfig secures the connection of the client with TLS, nil means plain TCP.
+	// Set Certificates for mutual TLS. It stays on the client side.
+	TLSConfig *tls.Config `json:"-"`
 }
 
 var DefaultOption = Option{
@@ -41,33 +67,131 @@ var DefaultOption = Option{
 	ConnectionTimeout: 10 * time.Second,
 }
 
+// newCodec returns the Codec negotiated in opt on conn.
+// maxFrameSize limits the bodies read in the binary framing, 0 means codec.DefaultMaxFrameSize.
+func newCodec(conn io.ReadWriteCloser, opt *Option, maxFrameSize int) (codec.Codec, error) {
+	if opt.BinaryHandshake {
+		return codec.NewFrameCodec(conn, codec.FrameOption{
+			CodeType:          opt.CodeType,
+			Compression:       opt.Compression,
+			CompressThreshold: opt.CompressThreshold,
+			MaxFrameSize:      maxFrameSize,
+		})
+	}
+	f := codec.NewCodeFuncMap[opt.CodeType]
+	if f == nil {
+		return nil, fmt.Errorf("unknown code type %q", opt.CodeType)
+	}
+	if opt.Compression == codec.CompressNone {
+		return f(conn), nil
+	}
+	return codec.NewCompressCodec(conn, f, opt.Compression, opt.CompressThreshold)
+}
+
 // !+ implement server
 
 // // Server represents an RPC Server.
 type Server struct {
-	serviceMap sync.Map // map[string]*service
+	serviceMap   sync.Map // map[string]*service
+	maxFrameSize int
+	interceptors []UnaryServerInterceptor
+	panicHandler PanicHandlerFunc
+
+	authenticators []Authenticator
+	acl            *ACL
+	methodLimits   []methodLimit
+	clientLimits   *clientLimiters
+	pool           *workerPool // nil means a goroutine per request
+
+	inShutdown int32 // accessed atomically, non-zero once Shutdown or Close is called
+
+	mu        sync.Mutex // protect following
+	listeners map[net.Listener]struct{}
+	conns     map[*serverConn]struct{}
+}
+
+// ServerOption configures a Server.
+type ServerOption func(*Server)
+
+// MaxFrameSize returns a ServerOption that sets the largest request body in bytes
+// the server accepts in the binary framing. Larger requests are skipped without
+// being decoded and answered with codec.ErrFrameTooLarge.
+func MaxFrameSize(n int) ServerOption {
+	return func(server *Server) {
+		server.maxFrameSize = n
+	}
+}
+
+// PanicHandlerFunc is called with the value a method panicked with. The
+// returned error is sent to the caller instead of the default Internal status,
+// a nil error keeps the default.
+type PanicHandlerFunc func(ctx context.Context, info *UnaryServerInfo, p interface{}) error
+
+// PanicHandler returns a ServerOption that calls f when a method panics.
+// The server recovers the panic and logs the stack whether or not f is set.
+func PanicHandler(f PanicHandlerFunc) ServerOption {
+	return func(server *Server) {
+		server.panicHandler = f
+	}
 }
 
 // NewServer returns a new Server.
-func NewServer() *Server {
-	return &Server{}
+func NewServer(opts ...ServerOption) *Server {
+	server := new(Server)
+	for _, opt := range opts {
+		opt(server)
+	}
+	return server
 }
 
 // DefaultServer is the default instance of *Server.
 var DefaultServer = NewServer()
 
 // Accept accepts connections on the listener and serves requests
-// for each incoming connection.
+// for each incoming connection. Accept returns when the listener is
+// closed or the server is shut down, other accept errors are retried
+// after a delay.
 func (server *Server) Accept(lis net.Listener) {
+	server.accept(lis, server.ServerConn)
+}
+
+// accept accepts connections on the listener and serves each one with serve.
+func (server *Server) accept(lis net.Listener, serve func(net.Conn)) {
+	if !server.trackListener(lis, true) {
+		_ = lis.Close()
+		return
+	}
+	defer server.trackListener(lis, false)
+	var tempDelay time.Duration // how long to sleep on accept failure
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Printf("server: accept: %v", err)
+			if server.shuttingDown() || isClosedListener(err) {
+				return
+			}
+			if tempDelay == 0 {
+				tempDelay = 5 * time.Millisecond
+			} else {
+				tempDelay *= 2
+			}
+			if max := 1 * time.Second; tempDelay > max {
+				tempDelay = max
+			}
+			log.Printf("server: accept error: %v; retrying in %v", err, tempDelay)
+			time.Sleep(tempDelay)
+			continue
 		}
-		go server.ServerConn(conn)
+		tempDelay = 0
+		go serve(conn)
 	}
 }
 
+// isClosedListener reports whether err is the error of Accept on a closed
+// listener, net.ErrClosed is only defined since Go 1.16.
+func isClosedListener(err error) bool {
+	return strings.Contains(err.Error(), "use of closed network connection")
+}
+
 // Accept accepts connections on the listener and serves requests
 // to DefaultServer for each incoming connection.
 // Accept blocks; the caller typically invokes it in a go statement.
@@ -76,57 +200,295 @@ func Accept(lis net.Listener) { DefaultServer.Accept(lis) }
 // ServeConn runs the server on a single connection.
 // ServeConn blocks, serving the connection until the client hangs up.
 func (server *Server) ServerConn(conn net.Conn) {
+	server.serveConn(conn, nil)
+}
+
+// serveConn is ServerConn for a connection with the peer p, nil means the
+// peer at the other end of conn.
+func (server *Server) serveConn(conn net.Conn, p *Peer) {
+	sc := &serverConn{conn: conn, sending: new(sync.Mutex), wg: new(sync.WaitGroup)}
+	sc.ctx, sc.cancel = context.WithCancel(context.Background())
+	if !server.trackConn(sc, true) {
+		sc.cancel()
+		_ = conn.Close()
+		return
+	}
 	defer func() {
+		server.trackConn(sc, false)
+		sc.cancel()
 		_ = conn.Close()
 	}()
+	if err := handshakeTLS(conn, tlsHandshakeTimeout); err != nil {
+		log.Printf("server: ServerConn: tls handshake error:%v", err)
+		return
+	}
+	if p == nil {
+		p = connPeer(conn)
+	}
+	sc.ctx = context.WithValue(sc.ctx, peerKey{}, p)
+	r := bufio.NewReader(conn)
+	first, err := r.Peek(1)
+	if err != nil {
+		log.Printf("server: ServerConn: read option error:%v", err)
+		return
+	}
+
 	var opt Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Printf("server: ServerConn: decode option eror:%v", err)
+	if first[0] != '{' {
+		if err := readHandshake(r, &opt); err != nil {
+			log.Printf("server: ServerConn: read handshake error:%v", err)
+			return
+		}
+		opt.BinaryHandshake = true
+	} else {
+		// legacy JSON option
+		dec := json.NewDecoder(r)
+		if err := dec.Decode(&opt); err != nil {
+			log.Printf("server: ServerConn: decode option eror:%v", err)
+			return
+		}
+		if opt.MagicNumber != MagicNumber {
+			log.Printf("server: ServerConn: Unknown MagicNumber:%v", opt.MagicNumber)
+			return
+		}
+		// the decoder may have read ahead past the option, hand those bytes to the codec,
+		// except for the newline json.Encoder writes after the option.
+		r = bufio.NewReader(io.MultiReader(dec.Buffered(), r))
+		if b, err := r.Peek(1); err == nil && b[0] == '\n' {
+			_, _ = r.Discard(1)
+		}
+	}
+	sc.ctx = withCredentials(sc.ctx, opt.Credentials)
+	cc, err := newCodec(&bufferedConn{r: r, Conn: conn}, &opt, server.maxFrameSize)
+	if err != nil {
+		log.Printf("server: ServerConn: %v", err)
 		return
 	}
-	if opt.MagicNumber != MagicNumber {
-		log.Printf("server: ServerConn: Unknown MagicNumber:%v", opt.MagicNumber)
+	server.serverCodec(sc, cc, &opt)
+}
+
+// bufferedConn is a net.Conn whose reads are served from r first.
+type bufferedConn struct {
+	r io.Reader
+	net.Conn
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// serverConn is a connection being served.
+type serverConn struct {
+	conn    net.Conn        // nil for a request of an HTTP handler, see beginHTTPRequest
+	sending *sync.Mutex     // serialize writes of responses
+	wg      *sync.WaitGroup // requests being handled
+	// ctx is the parent of the contexts of all requests on the connection,
+	// it is cancelled when the connection is lost or closed by the server.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex // protect following
+	cc       codec.Codec
+	inFlight int                           // requests read but not yet answered
+	closed   bool                          // closed by the server, no more requests are handled
+	cancels  map[uint64]context.CancelFunc // cancel the requests being handled by seq
+	streams  map[uint64]*stream            // the open streams by seq
+}
+
+// setCancel records cancel as the cancel function of the request with seq,
+// a nil cancel removes it.
+func (sc *serverConn) setCancel(seq uint64, cancel context.CancelFunc) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if cancel == nil {
+		delete(sc.cancels, seq)
 		return
 	}
-	f := codec.NewCodeFuncMap[opt.CodeType]
-	if f == nil {
-		log.Printf("server: ServerConn: Unknown Codec type:%v", opt.CodeType)
+	if sc.cancels == nil {
+		sc.cancels = make(map[uint64]context.CancelFunc)
+	}
+	sc.cancels[seq] = cancel
+}
+
+// cancelRequest cancels the request with seq on behalf of the client.
+func (sc *serverConn) cancelRequest(seq uint64) {
+	sc.mu.Lock()
+	cancel := sc.cancels[seq]
+	delete(sc.cancels, seq)
+	sc.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// beginRequest counts a request as in flight.
+// It reports false if the connection has been closed by the server.
+func (sc *serverConn) beginRequest() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.closed {
+		return false
+	}
+	sc.inFlight++
+	sc.wg.Add(1)
+	return true
+}
+
+func (sc *serverConn) endRequest() {
+	sc.mu.Lock()
+	sc.inFlight--
+	sc.mu.Unlock()
+	sc.wg.Done()
+}
+
+// closeIfIdle closes the connection if no request is in flight.
+func (sc *serverConn) closeIfIdle() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.inFlight > 0 {
+		return false
+	}
+	sc.closeLocked()
+	return true
+}
+
+func (sc *serverConn) close() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.closeLocked()
+}
+
+func (sc *serverConn) closeLocked() {
+	sc.closed = true
+	if sc.conn == nil {
+		// the HTTP handler answers the request once its context is done
+		sc.cancel()
 		return
 	}
-	server.serverCodec(f(conn), &opt)
+	_ = sc.conn.Close()
+}
+
+// goAway tells the client to stop sending new calls.
+func (sc *serverConn) goAway(server *Server) {
+	sc.mu.Lock()
+	cc := sc.cc
+	sc.mu.Unlock()
+	if cc != nil {
+		server.sendResponse(cc, &codec.Header{Kind: codec.KindGoAway}, invalidRequest, sc.sending)
+	}
 }
 
 // serverCodec is like ServeConn but uses the specified codec to
 // decode requests and encode responses.
-func (server *Server) serverCodec(cc codec.Codec, opt *Option) {
-	sending := new(sync.Mutex)
-	wg := new(sync.WaitGroup)
+func (server *Server) serverCodec(sc *serverConn, cc codec.Codec, opt *Option) {
+	sc.mu.Lock()
+	sc.cc = cc
+	sc.mu.Unlock()
+	// Shutdown may have missed this connection while it was handshaking
+	if server.shuttingDown() {
+		sc.goAway(server)
+	}
 	for {
 		req, err := server.readRequest(cc)
+		if req != nil && isBatch(req.h.Kind) {
+			b, err := server.readBatch(cc, req, err)
+			if err != nil {
+				log.Println("rpc server: read batch err:", err)
+				break
+			}
+			if server.shuttingDown() {
+				b.fail(errServerShutdown)
+			}
+			if !sc.beginRequest() {
+				break
+			}
+			for i, req := range b.reqs {
+				if b.errs[i] == nil {
+					sc.acceptRequest(req, opt.HandleTimeout)
+				}
+			}
+			server.handleBatch(sc, cc, b, opt.HandleTimeout)
+			continue
+		}
 		if err != nil {
 			if req == nil {
 				break // it's not possible to recover, so close the connection
 			}
-			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
+			server.sendError(sc, cc, req.h, err)
+			continue
+		}
+		switch req.h.Kind {
+		case codec.KindCancel:
+			sc.cancelRequest(req.h.Seq)
+			continue
+		case codec.KindStreamMsg, codec.KindStreamEnd, codec.KindWindowUpdate:
+			if err := sc.receiveStream(cc, req.h); err != nil {
+				log.Println("rpc server: read stream message err:", err)
+			}
 			continue
 		}
-		wg.Add(1)
-		go server.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
-		continue
+		if server.shuttingDown() {
+			server.sendError(sc, cc, req.h, errServerShutdown)
+			continue
+		}
+		if !sc.beginRequest() {
+			break
+		}
+		sc.acceptRequest(req, opt.HandleTimeout)
+		if req.h.Kind == codec.KindStreamOpen {
+			server.openStream(sc, cc, req, opt.HandleTimeout)
+			continue
+		}
+		server.startRequest(sc, req, opt.HandleTimeout, func(body interface{}, ok bool) {
+			if ok {
+				server.sendResponse(cc, req.h, body, sc.sending)
+			}
+			sc.endRequest()
+		})
 	}
-	// We've seen that there are no more requests.
-	// Wait for responses to be sent before closing codec.
-	wg.Wait()
+	// We've seen that there are no more requests, and nobody is left to
+	// read the responses. Stop the handlers and wait for them before closing codec.
+	sc.cancel()
+	sc.wg.Wait()
 	_ = cc.Close()
 }
 
+// dispatch runs a request read by the read loop, on the worker pool if the
+// server has one. reject answers the request with the error it is shed with.
+func (server *Server) dispatch(run func(), reject func(err error), deadline time.Time) {
+	if server.pool == nil {
+		go run()
+		return
+	}
+	server.pool.submit(&task{run: run, reject: reject, deadline: deadline})
+}
+
+// sendError answers the request or stream with header h with err.
+func (server *Server) sendError(sc *serverConn, cc codec.Codec, h *codec.Header, err error) {
+	if h.Kind == codec.KindNotify {
+		log.Printf("rpc server: drop notification %s: %v", h.ServiceMethod, err)
+		return
+	}
+	if h.Kind == codec.KindStreamOpen {
+		h.Kind = codec.KindStreamEnd
+	}
+	status.Convert(err).ToHeader(h)
+	server.sendResponse(cc, h, invalidRequest, sc.sending)
+}
+
 // request stores all information of a call
 type request struct {
 	h            *codec.Header // header of request
 	argv, replyv reflect.Value // argv and replyv of request
+	md           metadata.MD   // metadata sent by the client
 	svc          *service
 	mtype        *methodType
+	deadline     time.Time // deadline of the caller, zero means none
+
+	// set by prepareRequest
+	ctx           context.Context // the request is handled with
+	cancel        context.CancelFunc
+	handleTimeout bool // HandleTimeout is tighter than the caller's deadline
 }
 
 func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
@@ -146,15 +508,42 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 	if err != nil {
 		return nil, err
 	}
-	req := &request{h: h}
+	// the metadata belongs to the request, the reply carries the trailer
+	req := &request{h: h, md: h.Metadata}
+	h.Metadata = nil
+	switch h.Kind {
+	case codec.KindCancel:
+		return req, cc.ReadBody(nil)
+	case codec.KindStreamMsg, codec.KindStreamEnd, codec.KindWindowUpdate:
+		// the body is read by the stream
+		return req, nil
+	}
+	if h.Timeout != 0 {
+		req.deadline = time.Now().Add(h.Timeout)
+	}
 
 	req.svc, req.mtype, err = server.findService(h.ServiceMethod)
 	if err != nil {
-		return nil, err
+		// discard the body so that the next request can still be read
+		_ = cc.ReadBody(nil)
+		return req, err
+	}
+	if stream := h.Kind == codec.KindStreamOpen; stream != req.mtype.stream {
+		_ = cc.ReadBody(nil)
+		if stream {
+			return req, status.Error(codes.Unimplemented, "rpc: method "+h.ServiceMethod+" is not a streaming method")
+		}
+		return req, status.Error(codes.Unimplemented, "rpc: method "+h.ServiceMethod+" is a streaming method")
 	}
 
+	if req.mtype.ArgType == nil {
+		// a stream whose messages follow
+		return req, cc.ReadBody(nil)
+	}
 	req.argv = req.mtype.newArgv()
-	req.replyv = req.mtype.newReplyv()
+	if !req.mtype.stream {
+		req.replyv = req.mtype.newReplyv()
+	}
 
 	// make sure that argvi is a pointer, ReadBody need a pointer as parameter
 	argvi := req.argv.Interface()
@@ -165,7 +554,10 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 	err = cc.ReadBody(argvi)
 	if err != nil {
 		log.Println("rpc server: read argv err:", err)
-		return nil, err
+		if err == codec.ErrFrameTooLarge {
+			return req, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		return req, status.Error(codes.InvalidArgument, err.Error())
 	}
 	return req, nil
 }
@@ -173,46 +565,413 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
 	sending.Lock()
 	defer sending.Unlock()
+	writeResponse(cc, h, body)
+}
+
+// writeResponse writes the response h with body, the sending lock must be held.
+// A body the codec can not encode is not written, the caller is answered with
+// codes.Internal instead so that it does not wait for a reply forever.
+func writeResponse(cc codec.Codec, h *codec.Header, body interface{}) {
 	err := cc.Write(h, body)
-	if err != nil {
+	if err == nil {
+		return
+	}
+	log.Println("rpc server: write response error:", err)
+	if body == invalidRequest {
+		return
+	}
+	status.Newf(codes.Internal, "rpc server: can not encode reply: %v", err).ToHeader(h)
+	if err := cc.Write(h, invalidRequest); err != nil {
 		log.Println("rpc server: write response error:", err)
 	}
 }
 
-func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
-	defer wg.Done()
-	called := make(chan struct{}, 1)
-	sent := make(chan struct{}, 1)
+// startRequest serves req on a goroutine of its own, or on the worker pool if
+// the server has one, and calls done with the body of the reply once it is
+// ready, see serveRequest. A request shed by the pool is answered with the
+// error it is shed with.
+func (server *Server) startRequest(sc *serverConn, req *request, timeout time.Duration, done func(body interface{}, ok bool)) {
+	server.dispatch(func() { server.serveRequest(sc, req, timeout, done) }, func(err error) {
+		sc.releaseRequest(req)
+		if req.h.Kind == codec.KindNotify {
+			log.Printf("rpc server: drop notification %s: %v", req.h.ServiceMethod, err)
+			done(nil, false)
+			return
+		}
+		status.Convert(err).ToHeader(req.h)
+		done(invalidRequest, true)
+	}, req.deadline)
+}
+
+// callRequest is startRequest waiting for the reply, for the HTTP handlers
+// answering a request at a time.
+func (server *Server) callRequest(sc *serverConn, req *request, timeout time.Duration) (body interface{}, ok bool) {
+	type reply struct {
+		body interface{}
+		ok   bool
+	}
+	ch := make(chan reply, 1)
+	server.startRequest(sc, req, timeout, func(body interface{}, ok bool) {
+		ch <- reply{body, ok}
+	})
+	r := <-ch
+	return r.body, r.ok
+}
+
+// serveRequest calls the method of req and calls done once with the body of
+// the reply, req.h is made the header of the reply. ok is false if no reply
+// is sent: for a notification, a request cancelled by the client, or a caller
+// that is gone. The method is not called at all if the request is done
+// already, such as a request cancelled while it waited.
+//
+// Without a worker pool the method runs on a goroutine of its own and done is
+// called as soon as the request is done. With a worker pool the method runs on
+// the worker, serveRequest returns once the method does, but a request timing
+// out meanwhile is answered on time.
+func (server *Server) serveRequest(sc *serverConn, req *request, timeout time.Duration, done func(body interface{}, ok bool)) {
+	tr := new(trailer)
+	ctx := context.WithValue(metadata.NewIncomingContext(req.ctx, req.md), trailerKey{}, tr)
+	var once sync.Once
+	finish := func(err error, called bool) {
+		once.Do(func() {
+			body, ok := server.replyOf(sc, req, ctx, tr, timeout, err, called)
+			sc.releaseRequest(req)
+			done(body, ok)
+		})
+	}
+	if ctx.Err() != nil {
+		finish(nil, false)
+		return
+	}
+	if server.pool != nil {
+		// Answer on time whoever still waits at the deadline: the client of a
+		// request timed out by HandleTimeout, or the caller of an HTTP request,
+		// which has no connection. A TCP client with its own deadline gave up.
+		if deadline, ok := ctx.Deadline(); ok && (req.handleTimeout || sc.conn == nil) {
+			timer := time.AfterFunc(time.Until(deadline), func() {
+				<-ctx.Done() // the timer of ctx may fire after this one
+				finish(nil, false)
+			})
+			defer timer.Stop()
+		}
+		err := server.safeInvoke(ctx, req)
+		finish(err, ctx.Err() == nil)
+		return
+	}
+	called := make(chan error, 1)
 	go func() {
-		err := req.svc.call(req.mtype, req.argv, req.replyv)
-		called <- struct{}{}
+		called <- server.safeInvoke(ctx, req)
+	}()
+	select {
+	case err := <-called:
+		finish(err, true)
+	case <-ctx.Done():
+		finish(nil, false)
+	}
+}
+
+// replyOf returns the body of the reply to req handled with ctx, see
+// serveRequest. err is the error of the method if called, called is false
+// if ctx is done before the method returns.
+func (server *Server) replyOf(sc *serverConn, req *request, ctx context.Context, tr *trailer,
+	timeout time.Duration, err error, called bool) (body interface{}, ok bool) {
+	notify := req.h.Kind == codec.KindNotify
+	if called {
+		if ctx.Err() == context.Canceled && sc.ctx.Err() == nil {
+			// cancelled by the client, which no longer waits for the reply
+			return nil, false
+		}
+		if notify {
+			if err != nil {
+				log.Printf("rpc server: notification %s: %v", req.h.ServiceMethod, err)
+			}
+			return nil, false
+		}
+		req.h.Metadata = tr.get()
 		if err != nil {
-			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
-			sent <- struct{}{}
-			return
+			status.Convert(err).ToHeader(req.h)
+			return invalidRequest, true
+		}
+		return req.replyv.Interface(), true
+	}
+	// The method may still be running, a method taking a context sees
+	// the cancellation. Nobody is waiting for a connection that is lost
+	// or for a caller whose deadline has passed.
+	if ctx.Err() == context.DeadlineExceeded && req.handleTimeout && !notify {
+		status.Newf(codes.DeadlineExceeded, "rpc server: request handle timeout: expect within %s", timeout).ToHeader(req.h)
+		return invalidRequest, true
+	}
+	return nil, false
+}
+
+// safeInvoke is invoke turning a panic of the method into an error.
+func (server *Server) safeInvoke(ctx context.Context, req *request) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = server.recoverPanic(ctx, req, p)
 		}
-		server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
-		sent <- struct{}{}
 	}()
+	return server.invoke(ctx, req)
+}
+
+// prepareRequest sets the context req is handled with, releaseRequest
+// must be called once req is done.
+func (sc *serverConn) prepareRequest(req *request, timeout time.Duration) {
+	req.ctx, req.cancel, req.handleTimeout = sc.requestContext(req, timeout)
+}
 
-	if timeout == 0 {
-		<-called
-		<-sent
+// acceptRequest prepares req read from the connection and registers its
+// cancel, a notification has no seq to be cancelled by. It is called before
+// req waits for a goroutine or a worker, so that a cancel frame read
+// meanwhile stops it.
+func (sc *serverConn) acceptRequest(req *request, timeout time.Duration) {
+	sc.prepareRequest(req, timeout)
+	if req.h.Kind != codec.KindNotify {
+		sc.setCancel(req.h.Seq, req.cancel)
+	}
+}
+
+// releaseRequest cancels the context of req and forgets its cancel.
+func (sc *serverConn) releaseRequest(req *request) {
+	if req.cancel == nil {
 		return
 	}
-	select {
-	case <-time.After(timeout):
-		req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
-		server.sendResponse(cc, req.h, invalidRequest, sending)
-	case <-called:
-		<-sent
+	req.cancel()
+	if req.h.Kind != codec.KindNotify {
+		sc.setCancel(req.h.Seq, nil)
+	}
+}
+
+// requestContext returns the context req is handled with. It enforces the
+// tighter of the caller's deadline and HandleTimeout, handleTimeout reports
+// whether HandleTimeout is the tighter one.
+func (sc *serverConn) requestContext(req *request, timeout time.Duration) (ctx context.Context, cancel context.CancelFunc, handleTimeout bool) {
+	deadline := req.deadline
+	if timeout > 0 {
+		if d := time.Now().Add(timeout); deadline.IsZero() || d.Before(deadline) {
+			deadline, handleTimeout = d, true
+		}
+	}
+	if deadline.IsZero() {
+		ctx, cancel = context.WithCancel(sc.ctx)
+	} else {
+		ctx, cancel = context.WithDeadline(sc.ctx, deadline)
+	}
+	return ctx, cancel, handleTimeout
+}
+
+// invoke authorizes and limits the call of req and calls its method through the server's interceptors.
+func (server *Server) invoke(ctx context.Context, req *request) error {
+	ctx, err := server.authorize(ctx, req.h.ServiceMethod, req.argv.Interface())
+	if err != nil {
+		return err
+	}
+	release, err := server.limit(ctx, req.mtype, req.h.ServiceMethod)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if len(server.interceptors) == 0 {
+		return req.svc.call(ctx, req.mtype, req.argv, req.replyv)
+	}
+	info := &UnaryServerInfo{Server: server, ServiceMethod: req.h.ServiceMethod}
+	handler := func(ctx context.Context, args, reply interface{}) error {
+		argv, replyv := reflect.ValueOf(args), reflect.ValueOf(reply)
+		if argv.Type() != req.mtype.ArgType || replyv.Type() != req.mtype.ReplyType {
+			return status.Errorf(codes.Internal, "rpc server: %s called with %T, %T, expect %s, %s",
+				req.h.ServiceMethod, args, reply, req.mtype.ArgType, req.mtype.ReplyType)
+		}
+		return req.svc.call(ctx, req.mtype, argv, replyv)
+	}
+	return ChainUnaryServer(server.interceptors...)(ctx, req.argv.Interface(), req.replyv.Interface(), info, handler)
+}
+
+// recoverPanic turns the panic p of the method of req into the error sent to
+// the caller. The panic value and stack are only logged, the caller gets a
+// sanitized message.
+func (server *Server) recoverPanic(ctx context.Context, req *request, p interface{}) error {
+	const size = 64 << 10
+	buf := make([]byte, size)
+	buf = buf[:runtime.Stack(buf, false)]
+	log.Printf("rpc server: panic serving %s: %v\n%s", req.h.ServiceMethod, p, buf)
+	atomic.AddUint64(&req.mtype.numPanics, 1)
+
+	if server.panicHandler != nil {
+		info := &UnaryServerInfo{Server: server, ServiceMethod: req.h.ServiceMethod}
+		if err := server.panicHandler(ctx, info, p); err != nil {
+			return err
+		}
+	}
+	return status.Error(codes.Internal, "rpc server: internal error serving "+req.h.ServiceMethod)
+}
+
+// errServerShutdown answers the requests arriving during shutdown. It is
+// ErrShutdown so that the client sees the same error whether the client or
+// the server is shutting down.
+var errServerShutdown = ErrShutdown
+
+// shutdownPollInterval is how often Shutdown checks for idle connections.
+const shutdownPollInterval = 10 * time.Millisecond
+
+func (server *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&server.inShutdown) != 0
+}
+
+// trackListener adds or removes lis from the listeners closed on shutdown.
+// It reports false if lis is added after the server has been shut down.
+func (server *Server) trackListener(lis net.Listener, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if add {
+		if server.shuttingDown() {
+			return false
+		}
+		if server.listeners == nil {
+			server.listeners = make(map[net.Listener]struct{})
+		}
+		server.listeners[lis] = struct{}{}
+	} else {
+		delete(server.listeners, lis)
+	}
+	return true
+}
+
+// trackConn adds or removes sc from the connections closed on shutdown.
+// It reports false if sc is added after the server has been shut down.
+func (server *Server) trackConn(sc *serverConn, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if add {
+		if server.shuttingDown() {
+			return false
+		}
+		if server.conns == nil {
+			server.conns = make(map[*serverConn]struct{})
+		}
+		server.conns[sc] = struct{}{}
+	} else {
+		delete(server.conns, sc)
+	}
+	return true
+}
+
+// beginHTTPRequest returns the serverConn an HTTP handler serves r with,
+// tracked as a connection with a request in flight so that Shutdown waits
+// for it and Close cancels it. It reports false if the server is shutting
+// down. endHTTPRequest must be called once r is answered.
+func (server *Server) beginHTTPRequest(r *http.Request) (*serverConn, bool) {
+	sc := &serverConn{sending: new(sync.Mutex), wg: new(sync.WaitGroup)}
+	sc.ctx, sc.cancel = context.WithCancel(withHTTPPeer(r.Context(), r))
+	if !server.trackConn(sc, true) {
+		sc.cancel()
+		return nil, false
+	}
+	if !sc.beginRequest() {
+		server.trackConn(sc, false)
+		sc.cancel()
+		return nil, false
+	}
+	return sc, true
+}
+
+func (server *Server) endHTTPRequest(sc *serverConn) {
+	sc.endRequest()
+	server.trackConn(sc, false)
+	sc.cancel()
+}
+
+func (server *Server) closeListenersLocked() error {
+	var err error
+	for lis := range server.listeners {
+		if cerr := lis.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(server.listeners, lis)
+	}
+	return err
+}
+
+// closeIdleConns closes the connections without in-flight requests
+// and reports whether all connections are closed.
+func (server *Server) closeIdleConns() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	quiescent := true
+	for sc := range server.conns {
+		if !sc.closeIfIdle() {
+			quiescent = false
+		}
+	}
+	return quiescent
+}
+
+// Shutdown gracefully shuts down the server without interrupting in-flight calls.
+// It closes all listeners, tells connected clients to stop sending new calls,
+// waits for the calls being handled to be answered and then closes the connections.
+// If ctx expires first, Shutdown closes the remaining connections and returns ctx's error.
+func (server *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&server.inShutdown, 1)
+	server.mu.Lock()
+	err := server.closeListenersLocked()
+	conns := make([]*serverConn, 0, len(server.conns))
+	for sc := range server.conns {
+		conns = append(conns, sc)
+	}
+	server.mu.Unlock()
+	for _, sc := range conns {
+		sc.goAway(server)
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if server.closeIdleConns() {
+			server.closePool()
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			server.closeConns()
+			server.closePool()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes all listeners and connections,
+// calls in flight are dropped. Use Shutdown to drain them.
+func (server *Server) Close() error {
+	atomic.StoreInt32(&server.inShutdown, 1)
+	server.mu.Lock()
+	err := server.closeListenersLocked()
+	server.mu.Unlock()
+	server.closeConns()
+	server.closePool()
+	return err
+}
+
+// closePool stops the worker pool, if any.
+func (server *Server) closePool() {
+	if server.pool != nil {
+		server.pool.close()
+	}
+}
+
+func (server *Server) closeConns() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for sc := range server.conns {
+		sc.close()
 	}
 }
 
 // Register publishes in the server the set of methods of the
 func (server *Server) Register(rcvr interface{}) error {
 	s := newService(rcvr)
+	for name, m := range s.method {
+		m.limiter = server.limiterFor(s.name + "." + name)
+	}
 	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
 		return errors.New("rpc: service already defined: " + s.name)
 	}
@@ -229,7 +988,7 @@ func Register(rcvr interface{}) error {
 func (server *Server) findService(ServiceMethod string) (*service, *methodType, error) {
 	dot := strings.LastIndex(ServiceMethod, ".")
 	if dot < 0 {
-		err := errors.New("rpc: service/method request ill-formed: " + ServiceMethod)
+		err := status.Error(codes.InvalidArgument, "rpc: service/method request ill-formed: "+ServiceMethod)
 		return nil, nil, err
 	}
 
@@ -238,14 +997,14 @@ func (server *Server) findService(ServiceMethod string) (*service, *methodType,
 
 	svci, ok := server.serviceMap.Load(serviceName)
 	if !ok {
-		err := errors.New("rpc: can't find service " + ServiceMethod)
+		err := status.Error(codes.Unimplemented, "rpc: can't find service "+ServiceMethod)
 		return nil, nil, err
 	}
 
 	svc := svci.(*service)
 	mtype := svc.method[methodName]
 	if mtype == nil {
-		err := errors.New("rpc: can't find method " + ServiceMethod)
+		err := status.Error(codes.Unimplemented, "rpc: can't find method "+ServiceMethod)
 		return nil, nil, err
 	}
 	return svc, mtype, nil
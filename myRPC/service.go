@@ -1,6 +1,7 @@
 package myRPC
 
 import (
+	"context"
 	"go/ast"
 	"go/token"
 	"log"
@@ -14,16 +15,33 @@ var reportErr = true
 // because Typeof takes an empty interface value. This is annoying.
 var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
 
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+
 type methodType struct {
-	method    reflect.Method
-	ArgType   reflect.Type
-	ReplyType reflect.Type
-	numCalls  uint64 // Count the number of method calls
+	method     reflect.Method
+	ArgType    reflect.Type
+	ReplyType  reflect.Type
+	hasContext bool     // the method takes a context.Context as its first argument
+	stream     bool     // the method sends its replies on a *ServerStream
+	numCalls   uint64   // Count the number of method calls
+	numPanics  uint64   // Count the number of method calls that panicked
+	numLimited uint64   // Count the number of method calls rejected by the limits
+	limiter    *limiter // the limits of the method, nil if none
 }
 
 // NumCalls get numCalls
 func (m *methodType) NumCalls() uint64 {
-	return m.numCalls
+	return atomic.LoadUint64(&m.numCalls)
+}
+
+// NumPanics get numPanics
+func (m *methodType) NumPanics() uint64 {
+	return atomic.LoadUint64(&m.numPanics)
+}
+
+// NumLimited get numLimited
+func (m *methodType) NumLimited() uint64 {
+	return atomic.LoadUint64(&m.numLimited)
 }
 
 // newArgv return same type about ArgType
@@ -73,16 +91,44 @@ func newService(rcvr interface{}) *service {
 	return s
 }
 
+// registerMethods registers the exported methods of the forms
+//
+//	func (t *T) MethodName(argType T1, replyType *T2) error
+//	func (t *T) MethodName(argType T1, stream *ServerStream) error
+//	func (t *T) MethodName(stream *ServerStream) error
+//
+// optionally with a context.Context as the first argument, which is cancelled
+// when the call times out, the connection is lost or the server is closed.
+// The last two forms are streaming methods, see ServerStream, their context
+// is the Context of the stream.
 func (s *service) registerMethods() {
 	s.method = map[string]*methodType{}
 	for m := 0; m < s.typ.NumMethod(); m++ {
 		method := s.typ.Method(m)
 		mType := method.Type
 		mname := method.Name
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		if mType.NumOut() != 1 {
+			continue
+		}
+		// skip the receiver and an optional context
+		in := 1
+		hasContext := mType.NumIn() > 1 && mType.In(1) == typeOfContext
+		if hasContext {
+			in++
+		}
+		if mType.NumIn() == in+1 && mType.In(in) == typeOfServerStream && mType.Out(0) == typeOfError {
+			s.method[mname] = &methodType{
+				method:     method,
+				ReplyType:  typeOfServerStream,
+				hasContext: hasContext,
+				stream:     true,
+			}
 			continue
 		}
-		argType := mType.In(1)
+		if mType.NumIn() != in+2 {
+			continue
+		}
+		argType := mType.In(in)
 		if !isExportedOrBuiltinType(argType) {
 			if reportErr {
 				log.Printf("rpc.Register: argument type of method %q is not exported: %q\n", mname, argType)
@@ -90,7 +136,8 @@ func (s *service) registerMethods() {
 			continue
 		}
 		// Second arg must be a pointer.
-		replyType := mType.In(2)
+		replyType := mType.In(in + 1)
+		stream := replyType == typeOfServerStream
 		if replyType.Kind() != reflect.Ptr {
 			if reportErr {
 				log.Printf("rpc.Register: reply type of method %q is not a pointer: %q\n", mname, replyType)
@@ -117,18 +164,24 @@ func (s *service) registerMethods() {
 			continue
 		}
 		s.method[mname] = &methodType{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
-			numCalls:  0,
+			method:     method,
+			ArgType:    argType,
+			ReplyType:  replyType,
+			hasContext: hasContext,
+			stream:     stream,
+			numCalls:   0,
 		}
 	}
 }
 
-func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+func (s *service) call(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
 	atomic.AddUint64(&m.numCalls, 1)
 	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
+	in := []reflect.Value{s.rcvr, argv, replyv}
+	if m.hasContext {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv}
+	}
+	returnValues := f.Call(in)
 	if errInter := returnValues[0].Interface(); errInter != nil {
 		return errInter.(error)
 	}
@@ -1,7 +1,9 @@
 package myRPC
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"reflect"
 	"testing"
 )
@@ -30,6 +32,20 @@ func _assert(condition bool, msg string, v ...interface{}) {
 	}
 }
 
+// startTestServer registers rcvrs on server and serves it with accept on a
+// free local port until the test ends. It returns the address of the listener.
+func startTestServer(t *testing.T, server *Server, accept func(*Server, net.Listener), rcvrs ...interface{}) string {
+	t.Helper()
+	for _, rcvr := range rcvrs {
+		_assert(server.Register(rcvr) == nil, "register %T", rcvr)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "listen: %v", err)
+	t.Cleanup(func() { _ = server.Close() })
+	go accept(server, l)
+	return l.Addr().String()
+}
+
 func TestNewService(t *testing.T) {
 	var foo Foo
 	s := newService(&foo)
@@ -46,6 +62,6 @@ func TestMethodType_Call(t *testing.T) {
 	argv := mType.newArgv()
 	replyv := mType.newReplyv()
 	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
-	err := s.call(mType, argv, replyv)
+	err := s.call(context.Background(), mType, argv, replyv)
 	_assert(err == nil && ((*replyv.Interface().(*Reply)).val) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
 }
@@ -0,0 +1,210 @@
+package myRPC
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"rpc/myRPC/codec"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Slow int
+
+func (s *Slow) Sleep(d time.Duration, reply *time.Duration) error {
+	time.Sleep(d)
+	*reply = d
+	return nil
+}
+
+// Gate's Pass signals started and blocks until the gate is opened.
+type Gate struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+// open lets the calls of Pass return, it may be called more than once.
+func (g *Gate) open() {
+	g.once.Do(func() { close(g.release) })
+}
+
+func newGate() *Gate {
+	return &Gate{started: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+func (g *Gate) Pass(n int, reply *int) error {
+	g.started <- struct{}{}
+	<-g.release
+	*reply = n
+	return nil
+}
+
+// acceptUntil returns a function accepting connections that closes
+// accepting when Accept returns.
+func acceptUntil(accepting chan struct{}) func(*Server, net.Listener) {
+	return func(server *Server, l net.Listener) {
+		server.Accept(l)
+		close(accepting)
+	}
+}
+
+func TestServer_ShutdownDrains(t *testing.T) {
+	server := NewServer()
+	gate := newGate()
+	defer gate.open()
+	accepting := make(chan struct{})
+	addr := startTestServer(t, server, acceptUntil(accepting), new(Slow), gate)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// an idle connection, Shutdown closes it once every connection is told to go away
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = conn.Close() }()
+	_assert(json.NewEncoder(conn).Encode(&DefaultOption) == nil, "handshake")
+	cc, err := newCodec(conn, &DefaultOption, 0)
+	_assert(err == nil, "codec: %v", err)
+	_assert(cc.Write(&codec.Header{ServiceMethod: "Slow.Sleep", Seq: 1}, time.Duration(0)) == nil, "write call")
+	var h codec.Header
+	_assert(cc.ReadHeader(&h) == nil && h.Seq == 1 && cc.ReadBody(nil) == nil, "expect the reply, got %+v", h)
+
+	var n int
+	call := client.Go("Gate.Pass", 1, &n, nil)
+	<-gate.started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- server.Shutdown(ctx)
+	}()
+
+	select {
+	case <-accepting:
+	case <-time.After(time.Second):
+		t.Fatal("Accept did not return after Shutdown")
+	}
+	goAway := false
+	for err == nil {
+		h = codec.Header{}
+		if err = cc.ReadHeader(&h); err == nil {
+			goAway = goAway || h.Kind == codec.KindGoAway
+			err = cc.ReadBody(nil)
+		}
+	}
+	_assert(goAway, "expect GoAway before the idle connection is closed")
+
+	// the GoAway comes before the reply of the call in flight
+	gate.open()
+	<-call.Done
+	_assert(call.Error == nil && n == 1, "in-flight call should complete, got %v", call.Error)
+	_assert(!client.IsAvailable(), "client should stop sending after the server goes away")
+	err = client.Call(context.Background(), "Slow.Sleep", time.Millisecond, new(time.Duration))
+	_assert(err == ErrShutdown, "expect ErrShutdown for a new call, got %v", err)
+	_assert(<-shutdown == nil, "Shutdown should drain without error")
+
+	_, err = Dial("tcp", addr)
+	_assert(err != nil, "expect dial to fail after Shutdown")
+}
+
+func TestServer_ShutdownContextExpires(t *testing.T) {
+	server := NewServer()
+	gate := newGate()
+	defer gate.open()
+	addr := startTestServer(t, server, (*Server).Accept, gate)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	call := client.Go("Gate.Pass", 1, new(int), nil)
+	<-gate.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	_assert(err == context.DeadlineExceeded, "expect DeadlineExceeded, got %v", err)
+
+	select {
+	case <-call.Done:
+		_assert(call.Error != nil, "expect the dropped call to fail")
+	case <-time.After(time.Second):
+		t.Fatal("call was not terminated by the forced close")
+	}
+}
+
+func TestServer_Close(t *testing.T) {
+	server := NewServer()
+	accepting := make(chan struct{})
+	addr := startTestServer(t, server, acceptUntil(accepting), new(Slow))
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply time.Duration
+	_assert(client.Call(context.Background(), "Slow.Sleep", time.Millisecond, &reply) == nil, "call before Close")
+
+	_assert(server.Close() == nil, "Close")
+	select {
+	case <-accepting:
+	case <-time.After(time.Second):
+		t.Fatal("Accept did not return after Close")
+	}
+	err = client.Call(context.Background(), "Slow.Sleep", time.Millisecond, &reply)
+	_assert(err != nil, "expect calls to fail after Close")
+}
+
+// flakyListener fails the first Accept with a temporary error.
+type flakyListener struct {
+	net.Listener
+	failed int32
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if atomic.CompareAndSwapInt32(&l.failed, 0, 1) {
+		return nil, errors.New("accept: too many open files")
+	}
+	return l.Listener.Accept()
+}
+
+func TestServer_AcceptRetries(t *testing.T) {
+	// the zero value is ready to use
+	server := new(Server)
+	accepting := make(chan struct{})
+	addr := startTestServer(t, server, func(server *Server, l net.Listener) {
+		server.Accept(&flakyListener{Listener: l})
+		close(accepting)
+	}, new(Slow))
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply time.Duration
+	err = client.Call(context.Background(), "Slow.Sleep", time.Millisecond, &reply)
+	_assert(err == nil, "expect a call after an accept error, got %v", err)
+
+	_assert(server.Close() == nil, "Close")
+	select {
+	case <-accepting:
+	case <-time.After(time.Second):
+		t.Fatal("Accept did not return after Close")
+	}
+}
+
+func TestServer_AcceptClosedListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "listen: %v", err)
+	accepting := make(chan struct{})
+	go acceptUntil(accepting)(NewServer(), l)
+
+	// a listener closed by its owner stops Accept, without retrying
+	_ = l.Close()
+	select {
+	case <-accepting:
+	case <-time.After(time.Second):
+		t.Fatal("Accept did not return after the listener was closed")
+	}
+}
@@ -0,0 +1,239 @@
+// Package status implements the errors returned by myRPC. A status has a code,
+// a message and optional typed details, all of them are carried to the caller
+// in the reply header by every codec.
+//
+// A method returns a status with Error or Errorf, any other error reaches
+// the caller with codes.Unknown. The caller inspects it with Code or FromError.
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"rpc/myRPC/codec"
+	"rpc/myRPC/codes"
+	"sync"
+)
+
+// Status represents an RPC status code, message, and details.
+// A nil *Status is OK.
+type Status struct {
+	code    codes.Code
+	message string
+	details []codec.Detail
+}
+
+// New returns a Status representing c and msg.
+func New(c codes.Code, msg string) *Status {
+	return &Status{code: c, message: msg}
+}
+
+// Newf returns New(c, fmt.Sprintf(format, a...)).
+func Newf(c codes.Code, format string, a ...interface{}) *Status {
+	return New(c, fmt.Sprintf(format, a...))
+}
+
+// Error returns an error representing c and msg. If c is OK, returns nil.
+func Error(c codes.Code, msg string) error {
+	return New(c, msg).Err()
+}
+
+// Errorf returns Error(c, fmt.Sprintf(format, a...)).
+func Errorf(c codes.Code, format string, a ...interface{}) error {
+	return Error(c, fmt.Sprintf(format, a...))
+}
+
+// Code returns the status code contained in s.
+func (s *Status) Code() codes.Code {
+	if s == nil {
+		return codes.OK
+	}
+	return s.code
+}
+
+// Message returns the message contained in s.
+func (s *Status) Message() string {
+	if s == nil {
+		return ""
+	}
+	return s.message
+}
+
+// Err returns an immutable error representing s; returns nil if s.Code() is OK.
+func (s *Status) Err() error {
+	if s.Code() == codes.OK {
+		return nil
+	}
+	return &statusError{s: s}
+}
+
+// WithDetails returns a new status with the provided details appended.
+// A detail is encoded as JSON, register its type with RegisterDetail so
+// that Details can decode it on the other side.
+func (s *Status) WithDetails(details ...interface{}) (*Status, error) {
+	if s.Code() == codes.OK {
+		return nil, errors.New("status: no error details for status with code OK")
+	}
+	p := &Status{code: s.code, message: s.message, details: append([]codec.Detail(nil), s.details...)}
+	for _, d := range details {
+		value, err := json.Marshal(d)
+		if err != nil {
+			return nil, err
+		}
+		p.details = append(p.details, codec.Detail{Type: detailName(reflect.TypeOf(d)), Value: value})
+	}
+	return p, nil
+}
+
+// Details returns the details of s. A detail of a registered type is decoded
+// into a pointer to a new value of the type, any other detail is returned
+// as a codec.Detail.
+func (s *Status) Details() []interface{} {
+	if s == nil || len(s.details) == 0 {
+		return nil
+	}
+	details := make([]interface{}, 0, len(s.details))
+	for _, d := range s.details {
+		detailMu.RLock()
+		t, ok := detailTypes[d.Type]
+		detailMu.RUnlock()
+		if !ok {
+			details = append(details, d)
+			continue
+		}
+		v := reflect.New(t).Interface()
+		if err := json.Unmarshal(d.Value, v); err != nil {
+			details = append(details, d)
+			continue
+		}
+		details = append(details, v)
+	}
+	return details
+}
+
+// String returns a human readable form of s.
+func (s *Status) String() string {
+	return fmt.Sprintf("rpc error: code = %s desc = %s", s.Code(), s.Message())
+}
+
+// FromHeader returns the status carried by the reply header h,
+// nil if h does not carry an error.
+func FromHeader(h *codec.Header) *Status {
+	c := codes.Code(h.Code)
+	if c == codes.OK {
+		if h.Error == "" {
+			return nil
+		}
+		// the reply of a server without status codes
+		c = codes.Unknown
+	}
+	return &Status{code: c, message: h.Error, details: h.Details}
+}
+
+// ToHeader stores s in the reply header h.
+func (s *Status) ToHeader(h *codec.Header) {
+	h.Code = uint32(s.Code())
+	h.Error = s.Message()
+	h.Details = nil
+	if s != nil {
+		h.Details = s.details
+	}
+}
+
+// statusError is the error of a status, it is returned by Status.Err.
+type statusError struct {
+	s *Status
+}
+
+// Error returns the message of the status, the same text a caller got before
+// status codes were introduced.
+func (e *statusError) Error() string {
+	return e.s.message
+}
+
+// Status returns the status of e.
+func (e *statusError) Status() *Status {
+	return e.s
+}
+
+// Is implements errors.Is functionality. A status error is equivalent if
+// the code and message are identical.
+func (e *statusError) Is(target error) bool {
+	t, ok := target.(*statusError)
+	if !ok {
+		return false
+	}
+	return e.s.code == t.s.code && e.s.message == t.s.message
+}
+
+// FromError returns the Status of err if it is, or wraps, a status error.
+// It returns nil, true for a nil err, and a status with codes.Unknown and
+// the message of err, false otherwise.
+func FromError(err error) (s *Status, ok bool) {
+	if err == nil {
+		return nil, true
+	}
+	var e *statusError
+	if errors.As(err, &e) {
+		return e.s, true
+	}
+	return New(codes.Unknown, err.Error()), false
+}
+
+// Convert is a convenience function which removes the need to handle the
+// boolean return value from FromError.
+func Convert(err error) *Status {
+	s, _ := FromError(err)
+	return s
+}
+
+// Code returns the code of err if it is a status error, codes.OK if err
+// is nil, and codes.Unknown otherwise.
+func Code(err error) codes.Code {
+	return Convert(err).Code()
+}
+
+// FromContextError converts a context error into a Status. It returns a
+// Status with codes.OK if err is nil, or a Status with codes.Unknown if err
+// is not a context error.
+func FromContextError(err error) *Status {
+	switch err {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return New(codes.DeadlineExceeded, err.Error())
+	case context.Canceled:
+		return New(codes.Canceled, err.Error())
+	default:
+		return New(codes.Unknown, err.Error())
+	}
+}
+
+var (
+	detailMu    sync.RWMutex
+	detailTypes = make(map[string]reflect.Type)
+)
+
+// RegisterDetail records the type of v so that Details decodes details of the type.
+func RegisterDetail(v interface{}) {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	detailMu.Lock()
+	defer detailMu.Unlock()
+	detailTypes[detailName(t)] = t
+}
+
+// detailName returns the name identifying the detail type t on the wire.
+func detailName(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Name() == "" {
+		return t.String()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
@@ -0,0 +1,75 @@
+package status
+
+import (
+	"errors"
+	"fmt"
+	"rpc/myRPC/codec"
+	"rpc/myRPC/codes"
+	"testing"
+)
+
+type BadRequest struct {
+	Field, Description string
+}
+
+func TestStatus_Code(t *testing.T) {
+	if c := Code(nil); c != codes.OK {
+		t.Fatalf("expect OK for nil error, got %s", c)
+	}
+	if c := Code(errors.New("plain")); c != codes.Unknown {
+		t.Fatalf("expect Unknown for plain error, got %s", c)
+	}
+	err := Error(codes.NotFound, "no such record")
+	if c := Code(fmt.Errorf("lookup: %w", err)); c != codes.NotFound {
+		t.Fatalf("expect NotFound for wrapped error, got %s", c)
+	}
+	if err.Error() != "no such record" {
+		t.Fatalf("got message %q", err.Error())
+	}
+	if Error(codes.OK, "fine") != nil {
+		t.Fatal("expect nil error for OK")
+	}
+}
+
+func TestStatus_Is(t *testing.T) {
+	sentinel := Error(codes.Unavailable, "connection is shut down")
+	if !errors.Is(Error(codes.Unavailable, "connection is shut down"), sentinel) {
+		t.Fatal("expect equal code and message to match")
+	}
+	if errors.Is(Error(codes.Internal, "connection is shut down"), sentinel) {
+		t.Fatal("expect different codes not to match")
+	}
+	if errors.Is(errors.New("connection is shut down"), sentinel) {
+		t.Fatal("expect plain error not to match")
+	}
+}
+
+func TestStatus_Details(t *testing.T) {
+	RegisterDetail(BadRequest{})
+	s, err := New(codes.InvalidArgument, "bad request").WithDetails(&BadRequest{Field: "name", Description: "empty"}, "note")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the details survive a round trip through the reply header
+	var h codec.Header
+	s.ToHeader(&h)
+	got := FromHeader(&h)
+	if got.Code() != codes.InvalidArgument || got.Message() != "bad request" {
+		t.Fatalf("got %v", got)
+	}
+	details := got.Details()
+	if len(details) != 2 {
+		t.Fatalf("expect 2 details, got %v", details)
+	}
+	if br, ok := details[0].(*BadRequest); !ok || br.Field != "name" || br.Description != "empty" {
+		t.Fatalf("got detail %#v", details[0])
+	}
+	if raw, ok := details[1].(codec.Detail); !ok || string(raw.Value) != `"note"` {
+		t.Fatalf("got detail %#v", details[1])
+	}
+
+	if _, err := New(codes.OK, "").WithDetails(BadRequest{}); err == nil {
+		t.Fatal("expect an error adding details to OK")
+	}
+}
@@ -0,0 +1,131 @@
+package myRPC
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	pb "rpc/grpc/1.introduction/proto"
+	"rpc/myRPC/codec"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/status"
+	"testing"
+)
+
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+func init() {
+	status.RegisterDetail(FieldViolation{})
+}
+
+// Strict rejects empty names with a status carrying a FieldViolation.
+type Strict int
+
+func (s *Strict) Check(args string, reply *string) error {
+	if args == "" {
+		st, err := status.New(codes.InvalidArgument, "strict: empty name").WithDetails(FieldViolation{Field: "name", Description: "must not be empty"})
+		if err != nil {
+			return err
+		}
+		return st.Err()
+	}
+	*reply = args
+	return nil
+}
+
+func (s *Strict) Hello(args *pb.HelloRequest, reply *pb.HelloReply) error {
+	return status.Errorf(codes.NotFound, "strict: no greeting for %s", args.Name)
+}
+
+func checkStatus(client *Client) {
+	var reply string
+	err := client.Call(context.Background(), "Strict.Check", "", &reply)
+	st, ok := status.FromError(err)
+	_assert(ok && st.Code() == codes.InvalidArgument && st.Message() == "strict: empty name", "got %v", err)
+	details := st.Details()
+	_assert(len(details) == 1, "expect 1 detail, got %v", details)
+	fv, ok := details[0].(*FieldViolation)
+	_assert(ok && fv.Field == "name", "got detail %#v", details[0])
+
+	err = client.Call(context.Background(), "Strict.Nope", "", &reply)
+	_assert(status.Code(err) == codes.Unimplemented, "expect Unimplemented, got %v", err)
+
+	err = client.Call(context.Background(), "Strict.Check", "ok", &reply)
+	_assert(err == nil && reply == "ok", "call after error: %v", err)
+}
+
+func TestStatus_RoundTrip(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, new(Strict))
+	for _, opt := range codecOptions() {
+		opt := opt
+		t.Run(fmt.Sprintf("%s binary=%v", opt.CodeType, opt.BinaryHandshake), func(t *testing.T) {
+			client, err := Dial("tcp", addr, &opt)
+			_assert(err == nil, "dial: %v", err)
+			defer func() { _ = client.Close() }()
+			checkStatus(client)
+		})
+	}
+
+	opt := DefaultOption
+	opt.CodeType = codec.ProtobufType
+	client, err := Dial("tcp", addr, &opt)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply pb.HelloReply
+	err = client.Call(context.Background(), "Strict.Hello", &pb.HelloRequest{Name: "myRPC"}, &reply)
+	_assert(status.Code(err) == codes.NotFound && err.Error() == "strict: no greeting for myRPC", "got %v", err)
+}
+
+func TestStatus_UnknownError(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, new(Echo))
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	var n int
+	err = client.Call(context.Background(), "Echo.Fail", 1, &n)
+	_assert(status.Code(err) == codes.Unknown && err.Error() == "echo: fail", "expect Unknown echo: fail, got %v", err)
+
+	_ = client.Close()
+	err = client.Call(context.Background(), "Echo.Fail", 1, &n)
+	_assert(errors.Is(err, ErrShutdown) && status.Code(err) == codes.Unavailable, "expect ErrShutdown, got %v", err)
+}
+
+func TestStatus_ServerShutdown(t *testing.T) {
+	server := NewServer()
+	gate := newGate()
+	defer gate.open()
+	addr := startTestServer(t, server, (*Server).Accept, gate)
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = conn.Close() }()
+	_assert(json.NewEncoder(conn).Encode(&DefaultOption) == nil, "handshake")
+	cc, err := newCodec(conn, &DefaultOption, 0)
+	_assert(err == nil, "codec: %v", err)
+
+	// a call in flight keeps the connection open during Shutdown
+	_assert(cc.Write(&codec.Header{ServiceMethod: "Gate.Pass", Seq: 1}, 1) == nil, "write call")
+	<-gate.started
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	var h codec.Header
+	_assert(cc.ReadHeader(&h) == nil && h.Kind == codec.KindGoAway, "expect GoAway, got %+v", h)
+	_assert(cc.ReadBody(nil) == nil, "read body")
+
+	// a call arriving during shutdown is answered with the error of a client shut down
+	_assert(cc.Write(&codec.Header{ServiceMethod: "Gate.Pass", Seq: 2}, 2) == nil, "write call")
+	h = codec.Header{}
+	_assert(cc.ReadHeader(&h) == nil && h.Seq == 2, "expect the reply of call 2, got %+v", h)
+	_assert(cc.ReadBody(nil) == nil, "read body")
+	err = status.FromHeader(&h).Err()
+	_assert(errors.Is(err, ErrShutdown) && status.Code(err) == codes.Unavailable, "expect ErrShutdown, got %v", err)
+
+	gate.open()
+	h = codec.Header{}
+	var n int
+	_assert(cc.ReadHeader(&h) == nil && h.Seq == 1 && h.Error == "", "expect the reply of call 1, got %+v", h)
+	_assert(cc.ReadBody(&n) == nil && n == 1, "read body")
+	_assert(<-shutdown == nil, "Shutdown should drain without error")
+}
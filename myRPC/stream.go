@@ -0,0 +1,612 @@
+package myRPC
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"rpc/myRPC/codec"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/metadata"
+	"rpc/myRPC/status"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// streamWindow is the number of messages a receiver lets the peer send
+// ahead of Recv.
+const streamWindow = 16
+
+var errStreamFlowControl = status.Error(codes.ResourceExhausted, "rpc: stream: message received beyond the flow control window")
+
+// stream is the state shared by both ends of a stream: the messages received
+// but not consumed by Recv yet, and the credit to send more messages.
+//
+// A receiver grants no credit before the first Recv, which tells it the type
+// to decode the messages into. It then grants streamWindow messages and grants
+// more as Recv consumes them. So the messages buffered for a stream are bounded,
+// and the goroutine reading the connection never waits for a slow stream.
+type stream struct {
+	seq   uint64
+	ctx   context.Context
+	write func(h *codec.Header, body interface{}) error // writes a frame under the sending lock
+
+	mu       sync.Mutex // protect following
+	elemType reflect.Type
+	queue    []reflect.Value // messages received, not consumed by Recv yet
+	granted  int             // messages the peer may still send
+	consumed int             // messages consumed since the last grant
+	recvErr  error           // no more messages arrive, io.EOF on a clean end
+	credit   int             // messages we may still send
+	sendErr  error           // no more messages may be sent
+	trailer  metadata.MD     // the trailer of the server, client side only
+
+	recvReady chan struct{}
+	sendReady chan struct{}
+}
+
+func newStream(ctx context.Context, seq uint64, write func(*codec.Header, interface{}) error) *stream {
+	return &stream{
+		seq:       seq,
+		ctx:       ctx,
+		write:     write,
+		recvReady: make(chan struct{}, 1),
+		sendReady: make(chan struct{}, 1),
+	}
+}
+
+// notify wakes up the goroutine waiting on ch, if any.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (s *stream) ctxErr() error {
+	return status.FromContextError(s.ctx.Err()).Err()
+}
+
+// send sends v as a message of the stream, it waits for credit from the peer.
+func (s *stream) send(v interface{}) error {
+	for {
+		s.mu.Lock()
+		if s.sendErr != nil {
+			err := s.sendErr
+			s.mu.Unlock()
+			return err
+		}
+		if s.credit > 0 {
+			s.credit--
+			s.mu.Unlock()
+			return s.write(&codec.Header{Seq: s.seq, Kind: codec.KindStreamMsg}, v)
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.sendReady:
+		case <-s.ctx.Done():
+			return s.ctxErr()
+		}
+	}
+}
+
+// recv stores the next message of the stream in v, which must be a pointer.
+// All the calls of recv on a stream must pass the same type.
+func (s *stream) recv(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("rpc: stream: Recv needs a non-nil pointer, got %T", v)
+	}
+	s.mu.Lock()
+	if s.elemType == nil {
+		s.elemType = rv.Type().Elem()
+		if s.recvErr == nil {
+			s.granted += streamWindow
+			s.mu.Unlock()
+			if err := s.grant(streamWindow); err != nil {
+				return err
+			}
+			s.mu.Lock()
+		}
+	} else if rv.Type().Elem() != s.elemType {
+		s.mu.Unlock()
+		return fmt.Errorf("rpc: stream: Recv of %s on a stream of %s", rv.Type().Elem(), s.elemType)
+	}
+	for {
+		if s.ctx.Err() != nil {
+			// the messages left are of no use once the stream is cancelled
+			s.mu.Unlock()
+			return s.ctxErr()
+		}
+		if len(s.queue) > 0 {
+			m := s.queue[0]
+			s.queue[0] = reflect.Value{}
+			s.queue = s.queue[1:]
+			s.consumed++
+			n := 0
+			if s.consumed >= streamWindow/2 && s.recvErr == nil {
+				n, s.consumed = s.consumed, 0
+				s.granted += n
+			}
+			s.mu.Unlock()
+			rv.Elem().Set(m.Elem())
+			if n > 0 {
+				return s.grant(n)
+			}
+			return nil
+		}
+		if s.recvErr != nil {
+			err := s.recvErr
+			s.mu.Unlock()
+			return err
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.recvReady:
+		case <-s.ctx.Done():
+			return s.ctxErr()
+		}
+		s.mu.Lock()
+	}
+}
+
+// grant lets the peer send n more messages.
+func (s *stream) grant(n int) error {
+	return s.write(&codec.Header{Seq: s.seq, Kind: codec.KindWindowUpdate, Window: uint32(n)}, invalidRequest)
+}
+
+// deliver reads the body of a message of the stream from cc and queues it
+// for recv. A message the peer had no credit for is dropped and fails the stream.
+// The returned error is the error of cc.
+func (s *stream) deliver(cc codec.Codec) error {
+	s.mu.Lock()
+	typ, ok, done := s.elemType, s.granted > 0, s.recvErr != nil
+	if ok && !done {
+		s.granted--
+	}
+	s.mu.Unlock()
+	if !ok || done {
+		if !done {
+			s.abort(errStreamFlowControl)
+		}
+		return cc.ReadBody(nil)
+	}
+	v := reflect.New(typ)
+	if err := cc.ReadBody(v.Interface()); err != nil {
+		s.abort(status.Error(codes.InvalidArgument, "rpc: stream: reading body "+err.Error()))
+		return err
+	}
+	s.mu.Lock()
+	s.queue = append(s.queue, v)
+	s.mu.Unlock()
+	notify(s.recvReady)
+	return nil
+}
+
+// addCredit lets us send n more messages.
+func (s *stream) addCredit(n uint32) {
+	s.mu.Lock()
+	s.credit += int(n)
+	s.mu.Unlock()
+	notify(s.sendReady)
+}
+
+// closeRecv ends the messages of the stream with err after the queued ones.
+func (s *stream) closeRecv(err error) {
+	s.mu.Lock()
+	if s.recvErr == nil {
+		s.recvErr = err
+	}
+	s.mu.Unlock()
+	notify(s.recvReady)
+}
+
+// closeSend makes send return err.
+func (s *stream) closeSend(err error) {
+	s.mu.Lock()
+	if s.sendErr == nil {
+		s.sendErr = err
+	}
+	s.mu.Unlock()
+	notify(s.sendReady)
+}
+
+// abort fails both directions of the stream with err.
+func (s *stream) abort(err error) {
+	s.closeRecv(err)
+	s.closeSend(err)
+}
+
+// ServerStream is the server side of a stream. A streaming method has one of the forms
+//
+//	func (t *T) MethodName(argType T1, stream *ServerStream) error
+//	func (t *T) MethodName(stream *ServerStream) error
+//
+// The first form gets a single argument and sends any number of replies with Send,
+// it is called with Client.NewServerStream. The second form receives any number
+// of messages from the client with Recv and sends any number of replies, it is
+// called with Client.NewStream. The stream ends when the method returns, and
+// the error of the method is the error of the stream.
+type ServerStream struct {
+	s   *stream
+	ctx context.Context // the context of s carrying the principal
+}
+
+var typeOfServerStream = reflect.TypeOf((*ServerStream)(nil))
+
+// Context returns the context of the stream. It is cancelled when the client
+// cancels the stream, the deadline passes or the connection is lost. It carries
+// the metadata of the client, and SetTrailer sets the trailer of the stream.
+func (ss *ServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+// Send sends m to the client. It blocks while the client has not received
+// enough of the messages sent before.
+func (ss *ServerStream) Send(m interface{}) error {
+	return ss.s.send(m)
+}
+
+// Recv stores the next message from the client in m, which must be a pointer.
+// It returns io.EOF once the client has called CloseSend and all the messages
+// sent before are received. All the calls of Recv on a stream must pass the
+// same type. A method of the first form has no message to receive.
+func (ss *ServerStream) Recv(m interface{}) error {
+	return ss.s.recv(m)
+}
+
+// ClientStream is the client side of a stream.
+type ClientStream struct {
+	s      *stream
+	client *Client
+	done   chan struct{} // closed once the stream is removed from the client
+}
+
+// Context returns the context the stream was created with.
+func (cs *ClientStream) Context() context.Context {
+	return cs.s.ctx
+}
+
+// Recv stores the next message from the server in m, which must be a pointer.
+// It returns io.EOF once the server has ended the stream successfully, or the
+// error of the stream otherwise. All the calls of Recv on a stream must pass
+// the same type.
+func (cs *ClientStream) Recv(m interface{}) error {
+	return cs.s.recv(m)
+}
+
+// Send sends m to the server. It blocks while the server has not received
+// enough of the messages sent before, other calls and streams on the client
+// are not blocked. It returns io.EOF once the server has ended the stream,
+// the error of the stream is returned by Recv.
+func (cs *ClientStream) Send(m interface{}) error {
+	return cs.s.send(m)
+}
+
+// CloseSend tells the server that the client has no more messages,
+// Recv of the server returns io.EOF after the messages sent before.
+func (cs *ClientStream) CloseSend() error {
+	return cs.closeSend(errSendClosed)
+}
+
+// closeSend makes Send return err and tells the server the client has no more messages.
+func (cs *ClientStream) closeSend(err error) error {
+	cs.s.mu.Lock()
+	if cs.s.sendErr != nil {
+		cs.s.mu.Unlock()
+		return nil
+	}
+	cs.s.sendErr = err
+	cs.s.mu.Unlock()
+	return cs.s.write(&codec.Header{Seq: cs.s.seq, Kind: codec.KindStreamEnd}, invalidRequest)
+}
+
+var errSendClosed = errors.New("rpc: stream: Send after CloseSend")
+
+// Trailer returns the trailer metadata set by the server. It is only available
+// after Recv has returned a non-nil error.
+func (cs *ClientStream) Trailer() metadata.MD {
+	cs.s.mu.Lock()
+	defer cs.s.mu.Unlock()
+	return cs.s.trailer
+}
+
+// watch cancels the stream on the server when ctx is done before the stream ends.
+func (cs *ClientStream) watch() {
+	select {
+	case <-cs.s.ctx.Done():
+		if cs.client.removeStream(cs.s.seq) != nil {
+			cs.client.sendCancel(cs.s.seq)
+		}
+		cs.s.abort(cs.s.ctxErr())
+	case <-cs.done:
+	}
+}
+
+// NewServerStream calls the streaming method serviceMethod with args, the
+// replies of the method are read with Recv of the returned stream.
+//
+// The stream must be read until Recv returns an error, or ctx cancelled,
+// otherwise the resources of the stream are not released.
+func (client *Client) NewServerStream(ctx context.Context, serviceMethod string, args interface{}) (*ClientStream, error) {
+	cs, err := client.newStream(ctx, serviceMethod, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := cs.closeSend(errSendOnServerStream); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// NewStream opens a stream to the streaming method serviceMethod that takes
+// no argument. The messages are exchanged with Send and Recv of the returned
+// stream in both directions, CloseSend tells the method that the client has
+// no more messages.
+//
+// The stream must be read until Recv returns an error, or ctx cancelled,
+// otherwise the resources of the stream are not released.
+func (client *Client) NewStream(ctx context.Context, serviceMethod string) (*ClientStream, error) {
+	return client.newStream(ctx, serviceMethod, invalidRequest)
+}
+
+var errSendOnServerStream = errors.New("rpc: stream: Send on a stream opened by NewServerStream")
+
+func (client *Client) newStream(ctx context.Context, serviceMethod string, args interface{}) (*ClientStream, error) {
+	h := &codec.Header{ServiceMethod: serviceMethod, Kind: codec.KindStreamOpen}
+	if deadline, ok := ctx.Deadline(); ok {
+		h.Timeout = time.Until(deadline)
+		if h.Timeout <= 0 {
+			return nil, status.Error(codes.DeadlineExceeded, "rpc: stream: "+context.DeadlineExceeded.Error())
+		}
+	}
+	h.Metadata, _ = metadata.FromOutgoingContext(ctx)
+
+	client.sending.Lock()
+	cs, err := client.registerStream(ctx)
+	if err != nil {
+		client.sending.Unlock()
+		return nil, err
+	}
+	h.Seq = cs.s.seq
+	err = client.cc.Write(h, args)
+	client.sending.Unlock()
+	if err != nil {
+		client.removeStream(h.Seq)
+		return nil, err
+	}
+	go cs.watch()
+	return cs, nil
+}
+
+// registerStream registers a new stream, it must be called with the sending lock held.
+func (client *Client) registerStream(ctx context.Context) (*ClientStream, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.closing || client.shutdown {
+		return nil, ErrShutdown
+	}
+	seq := client.seq
+	client.seq++
+	cs := &ClientStream{client: client, done: make(chan struct{})}
+	cs.s = newStream(ctx, seq, client.writeFrame)
+	client.streams[seq] = cs
+	return cs, nil
+}
+
+// removeStream removes the stream with seq, it returns nil if there is none.
+func (client *Client) removeStream(seq uint64) *ClientStream {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	cs := client.streams[seq]
+	if cs != nil {
+		delete(client.streams, seq)
+		close(cs.done)
+	}
+	return cs
+}
+
+// writeFrame writes a frame of a stream.
+func (client *Client) writeFrame(h *codec.Header, body interface{}) error {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	return client.cc.Write(h, body)
+}
+
+// receiveStream handles a frame of a stream read by the receive loop,
+// it reads the body of the frame.
+func (client *Client) receiveStream(h *codec.Header) error {
+	client.mu.Lock()
+	cs := client.streams[h.Seq]
+	client.mu.Unlock()
+	if cs == nil {
+		// the stream has been cancelled
+		return client.cc.ReadBody(nil)
+	}
+	switch h.Kind {
+	case codec.KindStreamMsg:
+		return cs.s.deliver(client.cc)
+	case codec.KindWindowUpdate:
+		cs.s.addCredit(h.Window)
+	case codec.KindStreamEnd:
+		client.removeStream(h.Seq)
+		err := status.FromHeader(h).Err()
+		if err == nil {
+			err = io.EOF
+		}
+		cs.s.mu.Lock()
+		cs.s.trailer = h.Metadata
+		cs.s.mu.Unlock()
+		cs.s.closeRecv(err)
+		cs.s.closeSend(io.EOF)
+	}
+	return client.cc.ReadBody(nil)
+}
+
+// openStream starts serving the stream opened by req. The stream is registered
+// before the read loop reads the next frame, which may belong to it.
+func (server *Server) openStream(sc *serverConn, cc codec.Codec, req *request, timeout time.Duration) {
+	tr := new(trailer)
+	ctx := context.WithValue(metadata.NewIncomingContext(req.ctx, req.md), trailerKey{}, tr)
+	write := func(h *codec.Header, body interface{}) error {
+		sc.sending.Lock()
+		defer sc.sending.Unlock()
+		return cc.Write(h, body)
+	}
+	ss := &ServerStream{s: newStream(ctx, req.h.Seq, write), ctx: ctx}
+	if req.mtype.ArgType != nil {
+		// the client sends nothing but the argument
+		ss.s.closeRecv(io.EOF)
+	}
+	sc.addStream(ss.s)
+	server.dispatch(func() { server.handleStream(sc, cc, req, ss, tr, timeout) }, func(err error) {
+		server.endStream(sc, cc, req, ss, tr, timeout, err, true)
+	}, req.deadline)
+}
+
+// handleStream calls the streaming method of req and ends the stream with its
+// error. Without a worker pool the method runs on a goroutine of its own and
+// the stream ends as soon as its context is done. With a worker pool the method
+// runs on the worker, but a stream timing out meanwhile ends on time.
+func (server *Server) handleStream(sc *serverConn, cc codec.Codec, req *request, ss *ServerStream, tr *trailer, timeout time.Duration) {
+	ctx := ss.s.ctx
+	var once sync.Once
+	finish := func(err error, called bool) {
+		once.Do(func() { server.endStream(sc, cc, req, ss, tr, timeout, err, called) })
+	}
+	if ctx.Err() != nil {
+		finish(nil, false)
+		return
+	}
+	if server.pool != nil {
+		if deadline, ok := ctx.Deadline(); ok && req.handleTimeout {
+			timer := time.AfterFunc(time.Until(deadline), func() {
+				<-ctx.Done() // the timer of ctx may fire after this one
+				finish(nil, false)
+			})
+			defer timer.Stop()
+		}
+		err := server.invokeStream(ctx, req, ss)
+		finish(err, ctx.Err() == nil)
+		return
+	}
+	called := make(chan error, 1)
+	go func() {
+		called <- server.invokeStream(ctx, req, ss)
+	}()
+	select {
+	case err := <-called:
+		finish(err, true)
+	case <-ctx.Done():
+		finish(nil, false)
+	}
+}
+
+// invokeStream authorizes and limits the stream of req handled with ctx
+// and calls its method, a panic of the method is turned into an error.
+func (server *Server) invokeStream(ctx context.Context, req *request, ss *ServerStream) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = server.recoverPanic(ctx, req, p)
+		}
+	}()
+	var args interface{}
+	if req.mtype.ArgType != nil {
+		args = req.argv.Interface()
+	}
+	if ss.ctx, err = server.authorize(ctx, req.h.ServiceMethod, args); err != nil {
+		return err
+	}
+	release, err := server.limit(ss.ctx, req.mtype, req.h.ServiceMethod)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return req.svc.callStream(req.mtype, req.argv, ss)
+}
+
+// endStream ends the stream of req and sends its end to the client. err is
+// the error of the method if called, called is false if the context of the
+// stream is done before the method returns.
+func (server *Server) endStream(sc *serverConn, cc codec.Codec, req *request, ss *ServerStream, tr *trailer,
+	timeout time.Duration, err error, called bool) {
+	defer sc.endRequest()
+	defer sc.releaseRequest(req)
+	defer sc.removeStream(req.h.Seq)
+	ctx := ss.s.ctx
+
+	h := &codec.Header{Seq: req.h.Seq, Kind: codec.KindStreamEnd}
+	if called {
+		if ctx.Err() == context.Canceled && sc.ctx.Err() == nil {
+			// cancelled by the client, which no longer waits for the stream
+			return
+		}
+		ss.s.abort(errStreamEnded)
+		status.Convert(err).ToHeader(h)
+		h.Metadata = tr.get()
+	} else {
+		ss.s.abort(ss.s.ctxErr())
+		if ctx.Err() != context.DeadlineExceeded || !req.handleTimeout {
+			return
+		}
+		status.Newf(codes.DeadlineExceeded, "rpc server: request handle timeout: expect within %s", timeout).ToHeader(h)
+	}
+	server.sendResponse(cc, h, invalidRequest, sc.sending)
+}
+
+var errStreamEnded = errors.New("rpc: stream: the method has returned")
+
+// receiveStream handles a frame of a stream read by the read loop,
+// it reads the body of the frame.
+func (sc *serverConn) receiveStream(cc codec.Codec, h *codec.Header) error {
+	sc.mu.Lock()
+	s := sc.streams[h.Seq]
+	sc.mu.Unlock()
+	if s == nil {
+		// the method has returned
+		return cc.ReadBody(nil)
+	}
+	switch h.Kind {
+	case codec.KindStreamMsg:
+		return s.deliver(cc)
+	case codec.KindWindowUpdate:
+		s.addCredit(h.Window)
+	case codec.KindStreamEnd:
+		s.closeRecv(io.EOF)
+	}
+	return cc.ReadBody(nil)
+}
+
+func (sc *serverConn) addStream(s *stream) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.streams == nil {
+		sc.streams = make(map[uint64]*stream)
+	}
+	sc.streams[s.seq] = s
+}
+
+func (sc *serverConn) removeStream(seq uint64) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delete(sc.streams, seq)
+}
+
+func (s *service) callStream(m *methodType, argv reflect.Value, ss *ServerStream) error {
+	atomic.AddUint64(&m.numCalls, 1)
+	in := []reflect.Value{s.rcvr}
+	if m.hasContext {
+		in = append(in, reflect.ValueOf(ss.Context()))
+	}
+	if m.ArgType != nil {
+		in = append(in, argv)
+	}
+	in = append(in, reflect.ValueOf(ss))
+	returnValues := m.method.Func.Call(in)
+	if errInter := returnValues[0].Interface(); errInter != nil {
+		return errInter.(error)
+	}
+	return nil
+}
@@ -0,0 +1,478 @@
+package myRPC
+
+import (
+	"context"
+	"fmt"
+	"io"
+	streampb "rpc/grpc/2.stream/proto"
+	"rpc/myRPC/codec"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/metadata"
+	"rpc/myRPC/status"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Page struct {
+	N    int
+	Fail bool // fail the stream after N items
+}
+
+type Item struct {
+	Index int
+	Name  string
+}
+
+// Pager streams pages of items.
+type Pager struct {
+	sent     int64         // items sent by List
+	sending  chan int      // if not nil, receives the index of each item before List sends it
+	stopped  chan error    // receives the error of a List stopped by its context
+	ignoring chan struct{} // signaled when Ignore starts
+}
+
+func newPager() *Pager {
+	return &Pager{stopped: make(chan error, 1), ignoring: make(chan struct{}, 1)}
+}
+
+func (p *Pager) List(args Page, stream *ServerStream) error {
+	for i := 0; i < args.N; i++ {
+		if p.sending != nil {
+			p.sending <- i
+		}
+		if err := stream.Send(&Item{Index: i, Name: fmt.Sprint("item ", i)}); err != nil {
+			select {
+			case p.stopped <- err:
+			default:
+			}
+			return err
+		}
+		atomic.AddInt64(&p.sent, 1)
+	}
+	_ = SetTrailer(stream.Context(), metadata.Pairs("count", fmt.Sprint(args.N)))
+	if args.Fail {
+		return status.Error(codes.DataLoss, "pager: page lost")
+	}
+	return nil
+}
+
+func (p *Pager) Count(args Page, reply *int) error {
+	*reply = args.N
+	return nil
+}
+
+func (p *Pager) Students(args *streampb.StreamRangeRequest, stream *ServerStream) error {
+	for i := args.Begin; i <= args.End; i++ {
+		if err := stream.Send(&streampb.StreamStuResponse{Name: fmt.Sprint("student ", i), Age: i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Greet streams n greetings to the name in the incoming metadata.
+func (p *Pager) Greet(ctx context.Context, n int, stream *ServerStream) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for i := 0; i < n; i++ {
+		if err := stream.Send("hello " + md.Get("name")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Drain counts the messages of the client until the context is done or the client closes.
+func (p *Pager) Drain(ctx context.Context, stream *ServerStream) error {
+	count := 0
+	for ctx.Err() == nil {
+		var n int
+		err := stream.Recv(&n)
+		if err == io.EOF {
+			return stream.Send(count)
+		}
+		if err != nil {
+			return err
+		}
+		count++
+	}
+	return ctx.Err()
+}
+
+func TestNewService_StreamMethods(t *testing.T) {
+	s := newService(&Pager{})
+	_assert(s.method["List"].stream && !s.method["Count"].stream, "List should be a streaming method")
+	for _, name := range []string{"Greet", "Drain"} {
+		m := s.method[name]
+		_assert(m != nil && m.stream && m.hasContext, "%s should be a streaming method taking a context", name)
+	}
+}
+
+func TestServerStream_Context(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, newPager())
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "name", "myRPC")
+
+	stream, err := client.NewServerStream(ctx, "Pager.Greet", 2)
+	_assert(err == nil, "open stream: %v", err)
+	for i := 0; i < 2; i++ {
+		var greeting string
+		err := stream.Recv(&greeting)
+		_assert(err == nil && greeting == "hello myRPC", "recv greeting %d: %v %q", i, err, greeting)
+	}
+	var greeting string
+	_assert(stream.Recv(&greeting) == io.EOF, "expect io.EOF")
+
+	cs, err := client.NewStream(ctx, "Pager.Drain")
+	_assert(err == nil, "open stream: %v", err)
+	for i := 0; i < 3; i++ {
+		_assert(cs.Send(i) == nil, "send %d", i)
+	}
+	_assert(cs.CloseSend() == nil, "close send")
+	var count int
+	err = cs.Recv(&count)
+	_assert(err == nil && count == 3, "expect 3 messages drained, got %d %v", count, err)
+}
+
+func TestServerStream_List(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, newPager())
+	for _, opt := range codecOptions() {
+		opt := opt
+		t.Run(fmt.Sprintf("%s binary=%v", opt.CodeType, opt.BinaryHandshake), func(t *testing.T) {
+			client, err := Dial("tcp", addr, &opt)
+			_assert(err == nil, "dial: %v", err)
+			defer func() { _ = client.Close() }()
+
+			// more items than the flow control window
+			n := 5*streamWindow + 3
+			stream, err := client.NewServerStream(context.Background(), "Pager.List", Page{N: n})
+			_assert(err == nil, "open stream: %v", err)
+			for i := 0; i < n; i++ {
+				var item Item
+				err := stream.Recv(&item)
+				_assert(err == nil && item.Index == i, "recv item %d: %v %+v", i, err, item)
+			}
+			var item Item
+			err = stream.Recv(&item)
+			_assert(err == io.EOF, "expect io.EOF, got %v", err)
+			_assert(stream.Trailer().Get("count") == fmt.Sprint(n), "wrong trailer %v", stream.Trailer())
+
+			// an error of the method ends the stream after the items sent before
+			stream, err = client.NewServerStream(context.Background(), "Pager.List", Page{N: 2, Fail: true})
+			_assert(err == nil, "open stream: %v", err)
+			for i := 0; i < 2; i++ {
+				err := stream.Recv(&item)
+				_assert(err == nil && item.Index == i, "recv item %d: %v", i, err)
+			}
+			err = stream.Recv(&item)
+			_assert(status.Code(err) == codes.DataLoss, "expect DataLoss, got %v", err)
+			err = stream.Recv(&item)
+			_assert(status.Code(err) == codes.DataLoss, "expect DataLoss again, got %v", err)
+		})
+	}
+}
+
+func TestServerStream_Protobuf(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, newPager())
+	opt := DefaultOption
+	opt.CodeType = codec.ProtobufType
+	client, err := Dial("tcp", addr, &opt)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.NewServerStream(context.Background(), "Pager.Students", &streampb.StreamRangeRequest{Begin: 1, End: 20})
+	_assert(err == nil, "open stream: %v", err)
+	for i := int32(1); i <= 20; i++ {
+		var stu streampb.StreamStuResponse
+		err := stream.Recv(&stu)
+		_assert(err == nil && stu.Age == i, "recv student %d: %v", i, err)
+	}
+	var stu streampb.StreamStuResponse
+	_assert(stream.Recv(&stu) == io.EOF, "expect io.EOF")
+}
+
+func TestServerStream_FlowControl(t *testing.T) {
+	p := newPager()
+	p.sending = make(chan int, streamWindow+1)
+	addr := startTestServer(t, NewServer(), (*Server).Accept, p)
+	// cancel frames need the binary handshake
+	opt := DefaultOption
+	opt.BinaryHandshake = true
+	client, err := Dial("tcp", addr, &opt)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.NewServerStream(ctx, "Pager.List", Page{N: 1000})
+	_assert(err == nil, "open stream: %v", err)
+	var item Item
+	_assert(stream.Recv(&item) == nil, "recv first item")
+
+	// the server stops at the window while nobody reads the stream,
+	// and the other calls on the connection go on
+	for i := 0; i <= streamWindow; i++ {
+		<-p.sending
+	}
+	var n int
+	err = client.Call(context.Background(), "Pager.Count", Page{N: 7}, &n)
+	_assert(err == nil && n == 7, "call Pager.Count: %v %d", err, n)
+	sent := atomic.LoadInt64(&p.sent)
+	_assert(sent <= streamWindow, "expect at most %d items sent, got %d", streamWindow, sent)
+
+	// cancelling the stream stops the method
+	cancel()
+	err = stream.Recv(&item)
+	_assert(status.Code(err) == codes.Canceled, "expect Canceled, got %v", err)
+	select {
+	case err := <-p.stopped:
+		_assert(status.Code(err) == codes.Canceled, "expect the method to be cancelled, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("the method was not cancelled")
+	}
+}
+
+func TestServerStream_Deadline(t *testing.T) {
+	p := newPager()
+	addr := startTestServer(t, NewServer(), (*Server).Accept, p)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	stream, err := client.NewServerStream(ctx, "Pager.List", Page{N: 1000})
+	_assert(err == nil, "open stream: %v", err)
+	var item Item
+	_assert(stream.Recv(&item) == nil, "recv first item")
+
+	// the server stops at the window until the deadline
+	<-ctx.Done()
+	for err == nil {
+		err = stream.Recv(&item)
+	}
+	_assert(status.Code(err) == codes.DeadlineExceeded, "expect DeadlineExceeded, got %v", err)
+	select {
+	case err := <-p.stopped:
+		_assert(err != nil, "expect the method to be stopped")
+	case <-time.After(time.Second):
+		t.Fatal("the method was not stopped")
+	}
+}
+
+func TestServerStream_WrongShape(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, newPager())
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var n int
+	err = client.Call(context.Background(), "Pager.List", Page{N: 1}, &n)
+	_assert(status.Code(err) == codes.Unimplemented, "expect Unimplemented for a call of a stream, got %v", err)
+
+	stream, err := client.NewServerStream(context.Background(), "Pager.Count", Page{N: 1})
+	_assert(err == nil, "open stream: %v", err)
+	err = stream.Recv(&n)
+	_assert(status.Code(err) == codes.Unimplemented, "expect Unimplemented for a stream of a call, got %v", err)
+
+	stream, err = client.NewServerStream(context.Background(), "Pager.Nope", Page{N: 1})
+	_assert(err == nil, "open stream: %v", err)
+	err = stream.Recv(&n)
+	_assert(status.Code(err) == codes.Unimplemented, "expect Unimplemented for an unknown method, got %v", err)
+}
+
+// Sum adds up the numbers sent by the client.
+func (p *Pager) Sum(stream *ServerStream) error {
+	total := 0
+	for {
+		var n int
+		err := stream.Recv(&n)
+		if err == io.EOF {
+			return stream.Send(total)
+		}
+		if err != nil {
+			return err
+		}
+		total += n
+	}
+}
+
+// Echo sends back every item it receives.
+func (p *Pager) Echo(stream *ServerStream) error {
+	for {
+		var item Item
+		err := stream.Recv(&item)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&item); err != nil {
+			return err
+		}
+	}
+}
+
+// Ignore never receives the messages of the client.
+func (p *Pager) Ignore(stream *ServerStream) error {
+	p.ignoring <- struct{}{}
+	<-stream.Context().Done()
+	p.stopped <- stream.Context().Err()
+	return nil
+}
+
+func (p *Pager) Update(stream *ServerStream) error {
+	var ok int32
+	for {
+		var req streampb.StreamUpdateRequest
+		err := stream.Recv(&req)
+		if err == io.EOF {
+			return stream.Send(&streampb.StreamOKResponse{OK: ok})
+		}
+		if err != nil {
+			return err
+		}
+		ok++
+	}
+}
+
+func TestClientStream_Sum(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, newPager())
+	for _, opt := range codecOptions() {
+		opt := opt
+		t.Run(fmt.Sprintf("%s binary=%v", opt.CodeType, opt.BinaryHandshake), func(t *testing.T) {
+			client, err := Dial("tcp", addr, &opt)
+			_assert(err == nil, "dial: %v", err)
+			defer func() { _ = client.Close() }()
+
+			stream, err := client.NewStream(context.Background(), "Pager.Sum")
+			_assert(err == nil, "open stream: %v", err)
+			want := 0
+			for i := 0; i < 3*streamWindow; i++ {
+				_assert(stream.Send(i) == nil, "send %d", i)
+				want += i
+			}
+			_assert(stream.CloseSend() == nil, "close send")
+			_assert(stream.Send(1) != nil, "expect an error sending after CloseSend")
+			var total int
+			err = stream.Recv(&total)
+			_assert(err == nil && total == want, "recv total: %v %d, expect %d", err, total, want)
+			_assert(stream.Recv(&total) == io.EOF, "expect io.EOF")
+		})
+	}
+}
+
+func TestClientStream_Echo(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, newPager())
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.NewStream(context.Background(), "Pager.Echo")
+	_assert(err == nil, "open stream: %v", err)
+	n := 4 * streamWindow
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := stream.Send(&Item{Index: i}); err != nil {
+				return
+			}
+		}
+		_ = stream.CloseSend()
+	}()
+	for i := 0; i < n; i++ {
+		var item Item
+		err := stream.Recv(&item)
+		_assert(err == nil && item.Index == i, "recv item %d: %v %+v", i, err, item)
+	}
+	var item Item
+	_assert(stream.Recv(&item) == io.EOF, "expect io.EOF")
+	_assert(stream.Send(&item) == errSendClosed, "expect an error sending after CloseSend")
+}
+
+func TestClientStream_Protobuf(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, newPager())
+	opt := DefaultOption
+	opt.CodeType = codec.ProtobufType
+	client, err := Dial("tcp", addr, &opt)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.NewStream(context.Background(), "Pager.Update")
+	_assert(err == nil, "open stream: %v", err)
+	for i := int32(1); i <= 3; i++ {
+		_assert(stream.Send(&streampb.StreamUpdateRequest{Id: i, Age: 20 + i}) == nil, "send %d", i)
+	}
+	_assert(stream.CloseSend() == nil, "close send")
+	var reply streampb.StreamOKResponse
+	err = stream.Recv(&reply)
+	_assert(err == nil && reply.OK == 3, "recv: %v %d", err, reply.OK)
+}
+
+func TestClientStream_Backpressure(t *testing.T) {
+	p := newPager()
+	addr := startTestServer(t, NewServer(), (*Server).Accept, p)
+	// cancel frames need the binary handshake
+	opt := DefaultOption
+	opt.BinaryHandshake = true
+	client, err := Dial("tcp", addr, &opt)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.NewStream(ctx, "Pager.Ignore")
+	_assert(err == nil, "open stream: %v", err)
+	var sent int64
+	sendErr := make(chan error, 1)
+	go func() {
+		for {
+			if err := stream.Send(1); err != nil {
+				sendErr <- err
+				return
+			}
+			atomic.AddInt64(&sent, 1)
+		}
+	}()
+
+	// the server does not receive, so Send waits without blocking the connection
+	<-p.ignoring
+	var n int
+	err = client.Call(context.Background(), "Pager.Count", Page{N: 3}, &n)
+	_assert(err == nil && n == 3, "call Pager.Count: %v %d", err, n)
+	_assert(atomic.LoadInt64(&sent) == 0, "expect no message sent, got %d", atomic.LoadInt64(&sent))
+
+	cancel()
+	select {
+	case err := <-sendErr:
+		_assert(status.Code(err) == codes.Canceled, "expect Canceled, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("Send was not cancelled")
+	}
+	select {
+	case err := <-p.stopped:
+		_assert(err == context.Canceled, "expect the method to be cancelled, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("the method was not cancelled")
+	}
+}
+
+func TestServerStream_SendOnly(t *testing.T) {
+	addr := startTestServer(t, NewServer(), (*Server).Accept, newPager())
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.NewServerStream(context.Background(), "Pager.List", Page{N: 1})
+	_assert(err == nil, "open stream: %v", err)
+	_assert(stream.Send(1) != nil, "expect an error sending on a server stream")
+
+	// a method taking only a stream can't be called with an argument
+	stream, err = client.NewServerStream(context.Background(), "Pager.Sum", 1)
+	_assert(err == nil, "open stream: %v", err)
+	var total int
+	err = stream.Recv(&total)
+	_assert(err == nil && total == 0, "recv: %v %d", err, total)
+}
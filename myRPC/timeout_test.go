@@ -2,7 +2,12 @@ package myRPC
 
 import (
 	"context"
+	"encoding/json"
+	"io"
 	"net"
+	"rpc/myRPC/codec"
+	"rpc/myRPC/codes"
+	"rpc/myRPC/status"
 	"strings"
 	"testing"
 	"time"
@@ -15,6 +20,19 @@ func (b Bar) Timeout(argv int, reply *int) error {
 	return nil
 }
 
+// barCanceled receives the error of Bar.Cancelable once its context is done.
+var barCanceled = make(chan error, 1)
+
+func (b Bar) Cancelable(ctx context.Context, argv int, reply *int) error {
+	select {
+	case <-time.After(time.Second * 2):
+		return nil
+	case <-ctx.Done():
+		barCanceled <- ctx.Err()
+		return ctx.Err()
+	}
+}
+
 func startServer(addr chan string) {
 	var b Bar
 	_ = Register(&b)
@@ -32,10 +50,33 @@ func TestClient_Call(t *testing.T) {
 	time.Sleep(time.Second)
 	t.Run("client timeout", func(t *testing.T) {
 		client, _ := Dial("tcp", addr)
-		ctx, _ := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
 		var reply int
 		err := client.Call(ctx, "Bar.Timeout", 1, &reply)
-		_assert(err != nil && strings.Contains(err.Error(), ctx.Err().Error()), "expect a timeout error")
+		_assert(status.Code(err) == codes.DeadlineExceeded, "expect a timeout error, got %v", err)
+	})
+	t.Run("client cancel", func(t *testing.T) {
+		// cancel frames need the binary handshake
+		opt := DefaultOption
+		opt.BinaryHandshake = true
+		client, _ := Dial("tcp", addr, &opt)
+		defer func() { _ = client.Close() }()
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		var reply int
+		err := client.Call(ctx, "Bar.Cancelable", 1, &reply)
+		_assert(status.Code(err) == codes.Canceled, "expect a cancel error, got %v", err)
+		// the server stops the method long before it would finish on its own
+		select {
+		case err := <-barCanceled:
+			_assert(err == context.Canceled, "expect context canceled on the server, got %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("server did not cancel Bar.Cancelable")
+		}
+		// the connection is still usable after a cancel
+		err = client.Call(context.Background(), "Bar.Nope", 1, &reply)
+		_assert(status.Code(err) == codes.Unimplemented, "expect can't find method, got %v", err)
 	})
 	t.Run("server handle timeout", func(t *testing.T) {
 		// change it temporary
@@ -46,6 +87,63 @@ func TestClient_Call(t *testing.T) {
 		client, _ := Dial("tcp", addr)
 		var reply int
 		err := client.Call(context.Background(), "Bar.Timeout", 1, &reply)
-		_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error")
+		_assert(status.Code(err) == codes.DeadlineExceeded && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error, got %v", err)
 	})
 }
+
+func TestClient_CancelOldServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "listen: %v", err)
+	defer func() { _ = l.Close() }()
+	// a server that predates cancel frames, it would close the connection on one
+	got := make(chan string, 2)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		dec := json.NewDecoder(conn)
+		var opt Option
+		if dec.Decode(&opt) != nil {
+			return
+		}
+		rwc := struct {
+			io.Reader
+			io.Writer
+			io.Closer
+		}{io.MultiReader(dec.Buffered(), conn), conn, conn}
+		cc, err := newCodec(rwc, &opt, 0)
+		if err != nil {
+			return
+		}
+		for i := 0; i < 2; i++ {
+			var h codec.Header
+			var n int
+			if cc.ReadHeader(&h) != nil || cc.ReadBody(&n) != nil {
+				return
+			}
+			got <- h.ServiceMethod
+			if i == 1 {
+				_ = cc.Write(&codec.Header{ServiceMethod: h.ServiceMethod, Seq: h.Seq}, n)
+			}
+		}
+	}()
+
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error, 1)
+	go func() { cancelled <- client.Call(ctx, "Old.Slow", 1, new(int)) }()
+	_assert(<-got == "Old.Slow", "expect the first call")
+	cancel()
+	err = <-cancelled
+	_assert(status.Code(err) == codes.Canceled, "expect Canceled, got %v", err)
+
+	// the call is dropped by the client only, the connection keeps working
+	var reply int
+	err = client.Call(context.Background(), "Old.Fast", 2, &reply)
+	_assert(err == nil && reply == 2, "expect 2, got %d %v", reply, err)
+	_assert(<-got == "Old.Fast", "expect no cancel frame before the second call")
+}
@@ -0,0 +1,113 @@
+package myRPC
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Peer contains the information of the peer of a call, handlers get it
+// with PeerFromContext.
+type Peer struct {
+	// Addr is the address of the peer.
+	Addr net.Addr
+	// TLS is the state of the TLS connection of the peer, nil if the
+	// connection is not secured by TLS.
+	TLS *tls.ConnectionState
+}
+
+// Certificate returns the certificate the peer authenticated with, nil if
+// the peer sent none. With mutual TLS, tls.RequireAndVerifyClientCert in the
+// ClientAuth of the server's tls.Config, the certificate has been verified
+// against the ClientCAs, and its subject identifies the peer.
+func (p *Peer) Certificate() *x509.Certificate {
+	if p.TLS == nil || len(p.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return p.TLS.PeerCertificates[0]
+}
+
+type peerKey struct{}
+
+// PeerFromContext returns the peer of the call handled with ctx, if any.
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+// AcceptTLS is like Accept but serves TLS connections on lis with config.
+// For mutual TLS, set ClientAuth to tls.RequireAndVerifyClientCert and
+// ClientCAs to the pool of the CAs issuing the client certificates.
+func (server *Server) AcceptTLS(lis net.Listener, config *tls.Config) {
+	server.Accept(tls.NewListener(lis, config))
+}
+
+// tlsHandshakeTimeout bounds the TLS handshake of the connections a server
+// accepts, so that a client that never completes it does not hold the connection.
+var tlsHandshakeTimeout = 10 * time.Second
+
+// handshakeTLS completes the TLS handshake of conn, if it is a TLS
+// connection, so that the peer is known before the first request.
+// The handshake fails if it takes longer than timeout, 0 means no limit.
+func handshakeTLS(conn net.Conn, timeout time.Duration) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	if timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+	return tlsConn.Handshake()
+}
+
+// withPeer returns ctx carrying the peer of conn.
+func withPeer(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, peerKey{}, connPeer(conn))
+}
+
+// connPeer returns the peer of conn, the TLS handshake of conn is done.
+func connPeer(conn net.Conn) *Peer {
+	p := &Peer{Addr: conn.RemoteAddr()}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		p.TLS = &state
+	}
+	return p
+}
+
+// withHTTPPeer returns ctx carrying the peer of the HTTP request r.
+func withHTTPPeer(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, peerKey{}, httpPeer(r))
+}
+
+// httpPeer returns the peer of the HTTP request r.
+func httpPeer(r *http.Request) *Peer {
+	return &Peer{Addr: httpAddr(r.RemoteAddr), TLS: r.TLS}
+}
+
+// httpAddr is the address of the client of an HTTP request.
+type httpAddr string
+
+func (a httpAddr) Network() string { return "tcp" }
+func (a httpAddr) String() string  { return string(a) }
+
+// clientTLS returns conn secured by TLS with config, it verifies the
+// certificate of the server against the host of address unless config
+// sets ServerName or InsecureSkipVerify.
+func clientTLS(conn net.Conn, config *tls.Config, address string) *tls.Conn {
+	if config.ServerName == "" && !config.InsecureSkipVerify {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+	return tls.Client(conn, config)
+}
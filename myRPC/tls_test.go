@@ -0,0 +1,186 @@
+package myRPC
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type Whoami int
+
+// Name replies the common name of the certificate of the caller, "anonymous" without one.
+func (w *Whoami) Name(ctx context.Context, _ int, reply *string) error {
+	p, ok := PeerFromContext(ctx)
+	if !ok || p.Addr == nil {
+		*reply = "no peer"
+		return nil
+	}
+	*reply = "anonymous"
+	if cert := p.Certificate(); cert != nil {
+		*reply = cert.Subject.CommonName
+	}
+	return nil
+}
+
+// testCA issues certificates in memory.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA() *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_assert(err == nil, "generate key: %v", err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	_assert(err == nil, "create ca: %v", err)
+	cert, err := x509.ParseCertificate(der)
+	_assert(err == nil, "parse ca: %v", err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate named cn, for 127.0.0.1 if server.
+func (ca *testCA) issue(cn string, server bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_assert(err == nil, "generate key: %v", err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	_assert(err == nil, "create certificate: %v", err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// acceptTLS returns a function accepting TLS connections with config.
+func acceptTLS(config *tls.Config) func(*Server, net.Listener) {
+	return func(server *Server, l net.Listener) {
+		server.AcceptTLS(l, config)
+	}
+}
+
+func whoami(client *Client) (string, error) {
+	var name string
+	err := client.Call(context.Background(), "Whoami.Name", 0, &name)
+	return name, err
+}
+
+func TestServer_TLS(t *testing.T) {
+	ca := newTestCA()
+	addr := startTestServer(t, NewServer(), acceptTLS(&tls.Config{Certificates: []tls.Certificate{ca.issue("server", true)}}), new(Whoami))
+
+	opt := DefaultOption
+	opt.TLSConfig = &tls.Config{RootCAs: ca.pool}
+	client, err := Dial("tcp", addr, &opt)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+	name, err := whoami(client)
+	_assert(err == nil && name == "anonymous", "expect anonymous, got %q %v", name, err)
+
+	// the certificate of the server is not trusted
+	opt.TLSConfig = &tls.Config{RootCAs: newTestCA().pool}
+	_, err = Dial("tcp", addr, &opt)
+	_assert(err != nil, "expect an error for an untrusted server")
+}
+
+func TestServer_MutualTLS(t *testing.T) {
+	ca := newTestCA()
+	addr := startTestServer(t, NewServer(), acceptTLS(&tls.Config{
+		Certificates: []tls.Certificate{ca.issue("server", true)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	}), new(Whoami))
+
+	for _, opt := range codecOptions() {
+		opt := opt
+		opt.TLSConfig = &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{ca.issue("client-a", false)}}
+		client, err := Dial("tcp", addr, &opt)
+		_assert(err == nil, "dial: %v", err)
+		name, err := whoami(client)
+		_assert(err == nil && name == "client-a", "%s: expect client-a, got %q %v", opt.CodeType, name, err)
+		_ = client.Close()
+	}
+
+	// with TLS 1.3 the server rejects the client after the handshake of the
+	// client, so the error comes at the dial or at the first call
+	rejected := func(config *tls.Config) bool {
+		opt := DefaultOption
+		opt.TLSConfig = config
+		client, err := Dial("tcp", addr, &opt)
+		if err != nil {
+			return true
+		}
+		defer func() { _ = client.Close() }()
+		_, err = whoami(client)
+		return err != nil
+	}
+	_assert(rejected(&tls.Config{RootCAs: ca.pool}), "expect an error without a client certificate")
+	_assert(rejected(&tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{newTestCA().issue("mallory", false)}}),
+		"expect an error for a client certificate of another CA")
+}
+
+func TestServer_HTTPTLS(t *testing.T) {
+	ca := newTestCA()
+	server := NewServer()
+	_assert(server.Register(new(Whoami)) == nil, "register Whoami")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "listen: %v", err)
+	config := &tls.Config{
+		Certificates: []tls.Certificate{ca.issue("server", true)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	}
+	go func() { _ = http.Serve(tls.NewListener(l, config), server) }()
+
+	opt := DefaultOption
+	opt.TLSConfig = &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{ca.issue("client-a", false)}}
+	client, err := DialHTTP("tcp", l.Addr().String(), &opt)
+	_assert(err == nil, "dial http: %v", err)
+	defer func() { _ = client.Close() }()
+	name, err := whoami(client)
+	_assert(err == nil && name == "client-a", "expect client-a, got %q %v", name, err)
+}
+
+func TestServer_TLSHandshakeTimeout(t *testing.T) {
+	defer func(d time.Duration) { tlsHandshakeTimeout = d }(tlsHandshakeTimeout)
+	tlsHandshakeTimeout = 100 * time.Millisecond
+	ca := newTestCA()
+	addr := startTestServer(t, NewServer(), acceptTLS(&tls.Config{Certificates: []tls.Certificate{ca.issue("server", true)}}), new(Whoami))
+
+	// a client that never sends its hello is dropped
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	_assert(err == io.EOF, "expect the server to close the connection, got %v", err)
+}
@@ -0,0 +1,82 @@
+package myRPC
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/websocket"
+)
+
+// DefaultWebSocketPath is the path HandleWebSocket serves WebSocket connections on.
+const DefaultWebSocketPath = "/_goRPC_/websocket"
+
+// WebSocketHandler returns an http.Handler that upgrades requests to
+// WebSocket connections and serves each one like ServerConn. The protocol
+// runs unchanged over binary WebSocket messages, the client sends the Option
+// handshake first. Use it where only HTTP upgrades pass the proxies, unlike
+// the HTTP CONNECT of ServeHTTP. The peer of the calls is the client of the
+// upgrade request, not the Origin it claims.
+func (server *Server) WebSocketHandler() http.Handler {
+	return websocket.Server{Handler: func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.BinaryFrame
+		server.serveConn(ws, httpPeer(ws.Request()))
+	}}
+}
+
+// HandleWebSocket registers the WebSocketHandler of the server on path.
+// It is still necessary to invoke http.Serve(), typically in a go statement.
+func (server *Server) HandleWebSocket(path string) {
+	http.Handle(path, server.WebSocketHandler())
+}
+
+// HandleWebSocket registers the WebSocketHandler of DefaultServer on DefaultWebSocketPath.
+// It is still necessary to invoke http.Serve(), typically in a go statement.
+func HandleWebSocket() {
+	DefaultServer.HandleWebSocket(DefaultWebSocketPath)
+}
+
+// DialWebSocket connects to an RPC server serving WebSocket connections at
+// rawURL, such as "ws://example.com/_goRPC_/websocket" or "wss://..." for TLS
+// with the TLSConfig of the option.
+func DialWebSocket(rawURL string, opts ...*Option) (*Client, error) {
+	var opt *Option
+	switch len(opts) {
+	case 0:
+		opt = &DefaultOption
+	case 1:
+		opt = opts[0]
+	default:
+		return nil, errors.New("opts is too much,need one")
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	// the Origin header is required, say the client comes from the server itself
+	origin := &url.URL{Scheme: "http", Host: u.Host}
+	if u.Scheme == "wss" {
+		origin.Scheme = "https"
+	}
+	config, err := websocket.NewConfig(rawURL, origin.String())
+	if err != nil {
+		return nil, err
+	}
+	config.Dialer = &net.Dialer{Timeout: opt.ConnectionTimeout}
+	if opt.TLSConfig != nil {
+		config.TlsConfig = opt.TLSConfig
+	}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	client, err := NewClientWithOption(ws, opt)
+	if err != nil {
+		_ = ws.Close()
+		return nil, err
+	}
+	return client, nil
+}
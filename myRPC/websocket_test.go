@@ -0,0 +1,100 @@
+package myRPC
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Remote replies the address of its caller.
+type Remote int
+
+func (r *Remote) Addr(ctx context.Context, _ int, reply *string) error {
+	p, ok := PeerFromContext(ctx)
+	if !ok {
+		return errors.New("remote: no peer")
+	}
+	*reply = p.Addr.String()
+	return nil
+}
+
+func TestWebSocket(t *testing.T) {
+	server := NewServer()
+	_assert(server.Register(new(Calc)) == nil, "register Calc")
+	_assert(server.Register(new(Pager)) == nil, "register Pager")
+	ts := httptest.NewServer(server.WebSocketHandler())
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + DefaultWebSocketPath
+
+	for _, opt := range codecOptions() {
+		opt := opt
+		t.Run(fmt.Sprintf("%s binary=%v", opt.CodeType, opt.BinaryHandshake), func(t *testing.T) {
+			client, err := DialWebSocket(url, &opt)
+			_assert(err == nil, "dial: %v", err)
+			defer func() { _ = client.Close() }()
+
+			var sum int
+			err = client.Call(context.Background(), "Calc.Add", Args{Num1: 1, Num2: 2}, &sum)
+			_assert(err == nil && sum == 3, "expect 3, got %d %v", sum, err)
+
+			stream, err := client.NewServerStream(context.Background(), "Pager.List", Page{N: 20})
+			_assert(err == nil, "open stream: %v", err)
+			n := 0
+			for {
+				var item Item
+				err := stream.Recv(&item)
+				if err == io.EOF {
+					break
+				}
+				_assert(err == nil && item.Index == n, "expect item %d, got %+v %v", n, item, err)
+				n++
+			}
+			_assert(n == 20, "expect 20 items, got %d", n)
+		})
+	}
+
+	_, err := DialWebSocket("ws"+strings.TrimPrefix(ts.URL, "http")+"/", &DefaultOption, &DefaultOption)
+	_assert(err != nil, "expect an error for too many options")
+}
+
+func TestWebSocket_Peer(t *testing.T) {
+	// the limit of each client is keyed on the address of its peer
+	server := NewServer(ClientLimit(Limit{Rate: 100, Burst: 10}))
+	_assert(server.Register(new(Remote)) == nil, "register Remote")
+	_assert(server.Register(new(Whoami)) == nil, "register Whoami")
+	ts := httptest.NewServer(server.WebSocketHandler())
+	defer ts.Close()
+
+	client, err := DialWebSocket("ws" + strings.TrimPrefix(ts.URL, "http"))
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var addr string
+	err = client.Call(context.Background(), "Remote.Addr", 0, &addr)
+	_assert(err == nil, "call Remote.Addr: %v", err)
+	host, _, err := net.SplitHostPort(addr)
+	_assert(err == nil && host == "127.0.0.1", "expect the address of the socket, got %q %v", addr, err)
+
+	// over wss the peer carries the certificate of the client
+	ca := newTestCA()
+	tts := httptest.NewUnstartedServer(server.WebSocketHandler())
+	tts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{ca.issue("server", true)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	}
+	tts.StartTLS()
+	defer tts.Close()
+	opt := DefaultOption
+	opt.TLSConfig = &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{ca.issue("client-a", false)}}
+	client, err = DialWebSocket("wss"+strings.TrimPrefix(tts.URL, "https"), &opt)
+	_assert(err == nil, "dial wss: %v", err)
+	defer func() { _ = client.Close() }()
+	name, err := whoami(client)
+	_assert(err == nil && name == "client-a", "expect client-a, got %q %v", name, err)
+}
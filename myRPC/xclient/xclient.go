@@ -45,7 +45,11 @@ func (X *XClient) dial(addr string) (*myRPC.Client, error) {
 
 	if client == nil {
 		var err error
-		client, err = myRPC.Dial("tcp", addr)
+		if X.opt != nil {
+			client, err = myRPC.Dial("tcp", addr, X.opt)
+		} else {
+			client, err = myRPC.Dial("tcp", addr)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -84,6 +88,7 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	var e error
 	replyDone := reply == nil // if reply is nil, don't need to set value
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	for _, rpcAddr := range servers {
 		wg.Add(1)
 		go func(rpcAddr string) {